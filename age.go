@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Totals for files grouped by how long ago they were modified, for -by-age
+type ageBreakdown struct {
+	bounds []time.Duration // Upper bound of each bucket but the last, ascending
+	groups []groupTotal    // One per bucket, youngest first
+}
+
+/* Create an age breakdown with empty buckets
+ * Parameters:
+ *	- bounds: The ages that separate the buckets, ascending
+ * Returns:
+ *	- *ageBreakdown: The breakdown, with one more bucket than there are bounds
+ */
+func newAgeBreakdown(bounds []time.Duration) *ageBreakdown {
+	a := &ageBreakdown{bounds: bounds, groups: make([]groupTotal, len(bounds)+1)}
+	for i := range a.groups {
+		switch {
+		case i == 0:
+			a.groups[i].Key = "<" + formatAge(bounds[0])
+		case i == len(bounds):
+			a.groups[i].Key = ">" + formatAge(bounds[i-1])
+		default:
+			a.groups[i].Key = formatAge(bounds[i-1]) + "-" + formatAge(bounds[i])
+		}
+	}
+	return a
+}
+
+/* Add a file to the bucket for its age
+ * Parameters:
+ *	- mtime: The file's modification time
+ *	- size: The size the file contributed to the total
+ */
+func (a *ageBreakdown) add(mtime time.Time, size int64) {
+	age := time.Since(mtime)
+	i := 0
+	for i < len(a.bounds) && age >= a.bounds[i] {
+		i++
+	}
+	a.groups[i].Size += size
+	a.groups[i].Files++
+}
+
+/* Format an age bucket boundary compactly
+ * Parameters:
+ *	- d: The age
+ * Returns:
+ *	- string: The age in whole years or days if it is one, like "1y" or "30d",
+ *	  otherwise as a Go duration
+ */
+func formatAge(d time.Duration) string {
+	const day = 24 * time.Hour
+	switch {
+	case d%(365*day) == 0:
+		return fmt.Sprintf("%dy", d/(365*day))
+	case d%day == 0:
+		return fmt.Sprintf("%dd", d/day)
+	}
+	return d.String()
+}
+
+/* Parse the -age-buckets boundaries
+ * Parameters:
+ *	- s: Comma-separated ages, each a Go duration or a number of days ("30d") or
+ *	  365-day years ("1y")
+ * Returns:
+ *	- ([]time.Duration, error): The ages, or an error if one is invalid or they
+ *	  aren't in increasing order
+ */
+func parseAgeBuckets(s string) ([]time.Duration, error) {
+	var bounds []time.Duration
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		var d time.Duration
+		var err error
+		if n, ok := strings.CutSuffix(field, "y"); ok {
+			d, err = scaledDuration(n, 365*24*time.Hour)
+		} else if n, ok := strings.CutSuffix(field, "d"); ok {
+			d, err = scaledDuration(n, 24*time.Hour)
+		} else {
+			d, err = time.ParseDuration(field)
+		}
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid age %q: expected a duration like 12h, 30d or 1y", field)
+		}
+		if len(bounds) > 0 && d <= bounds[len(bounds)-1] {
+			return nil, fmt.Errorf("ages must be in increasing order, but %s follows %s", field, formatAge(bounds[len(bounds)-1]))
+		}
+		bounds = append(bounds, d)
+	}
+	return bounds, nil
+}
+
+/* Parse a number of some unit of time
+ * Parameters:
+ *	- n: The number, which may have a fraction
+ *	- unit: The length of the unit
+ * Returns:
+ *	- (time.Duration, error): The duration, or an error if n isn't a number
+ */
+func scaledDuration(n string, unit time.Duration) (time.Duration, error) {
+	f, err := strconv.ParseFloat(n, 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(f * float64(unit)), nil
+}
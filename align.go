@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Widths of the path and size columns with -align, found once every result is in
+var alignPathWidth, alignSizeWidth int
+
+/* Find how wide the path and size columns have to be for every line to line up
+ * Parameters:
+ *	- results: Per-directory results
+ *	- total: Cumulative totals of all directories, whose line is aligned too
+ */
+func setAlignment(results []dirResult, total dirResult) {
+	alignPathWidth, alignSizeWidth = 0, 0
+	widen := func(label string, size int64) {
+		alignPathWidth = max(alignPathWidth, utf8.RuneCountInString(label))
+		alignSizeWidth = max(alignSizeWidth, utf8.RuneCountInString(plainSize(size)))
+	}
+	if !quietFlag && totalTemplate == nil {
+		widen(total.Path, total.Size)
+	}
+	for _, r := range results {
+		if (r.Error != "" && !r.Partial) || summaryFlag {
+			continue
+		}
+		// A -tree outline's first line is the argument's own
+		if withinThreshold(r.Size) {
+			widen(r.Path, r.Size)
+		}
+		if r.Tree != nil {
+			for _, c := range r.Tree.Children {
+				c.walkLabels(1, widen)
+			}
+		}
+	}
+}
+
+/* Visit the label each line of a -tree outline is printed under
+ * Parameters:
+ *	- depth: How far below the argument n is
+ *	- visit: Called with each printed label and its size
+ */
+func (n *dirNode) walkLabels(depth int, visit func(label string, size int64)) {
+	if withinThreshold(n.Size) {
+		visit(treeLabel(n.Path, depth), n.Size)
+	}
+	for _, c := range n.Children {
+		c.walkLabels(depth+1, visit)
+	}
+}
+
+/* Give a line's path and size the column widths found by setAlignment
+ * Parameters:
+ *	- label: The path, or the total's label
+ *	- size: The size, already formatted
+ *	- bytes: The size in bytes, to measure it without any color codes
+ * Returns:
+ *	- string: The padded path and size, or the two as they are without -align
+ */
+func alignColumns(label, size string, bytes int64) string {
+	if !alignFlag {
+		return label + ": " + size
+	}
+	label += ":" + strings.Repeat(" ", max(alignPathWidth-utf8.RuneCountInString(label), 0))
+	size = strings.Repeat(" ", max(alignSizeWidth-utf8.RuneCountInString(plainSize(bytes)), 0)) + size
+	return label + " " + size
+}
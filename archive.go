@@ -0,0 +1,194 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+/* Check whether an argument should be measured as an archive
+ * Parameters:
+ *  - p: The argument
+ * Returns:
+ *  - bool: true if p is a regular file ending in .tar, .tar.gz, .tgz or .zip
+ */
+func isArchive(p string) bool {
+	name := strings.ToLower(p)
+	if !strings.HasSuffix(name, ".tar") && !strings.HasSuffix(name, ".tar.gz") && !strings.HasSuffix(name, ".tgz") && !isZip(p) {
+		return false
+	}
+	info, err := os.Stat(p)
+	return err == nil && info.Mode().IsRegular()
+}
+
+/* Check whether an archive argument is a ZIP file rather than a tar archive
+ * Parameters:
+ *  - p: The argument
+ * Returns:
+ *  - bool: true if p ends in .zip
+ */
+func isZip(p string) bool {
+	return strings.HasSuffix(strings.ToLower(p), ".zip")
+}
+
+/* Check whether file info describes an entry inside an archive
+ * Parameters:
+ *  - info: File info from the walk or an archive
+ * Returns:
+ *  - bool: true if the file only exists inside an archive, so it can't be opened
+ */
+func inArchive(info fs.FileInfo) bool {
+	switch info.Sys().(type) {
+	case *tar.Header, *zip.FileHeader:
+		return true
+	}
+	return false
+}
+
+/* Calculate the uncompressed size of the files in an archive, without
+ * extracting it
+ * Parameters:
+ *  - ctx: Cancelling this aborts the read
+ *  - p: Path to a .tar, .tar.gz, .tgz or .zip file
+ * Returns:
+ *  - (dirResult, error): Total size of the regular files in the archive and the
+ *    number of them counted, or an error if the archive couldn't be read
+ */
+func archiveSize(ctx context.Context, p string) (dirResult, error) {
+	if isZip(p) {
+		return zipSize(ctx, p)
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return dirResult{}, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if name := strings.ToLower(p); strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".tgz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return dirResult{}, fmt.Errorf("not a valid gzip-compressed archive: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	result := dirResult{Path: p}
+	tr := tar.NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return dirResult{}, err
+		}
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return dirResult{}, fmt.Errorf("not a valid tar archive: %w", err)
+		}
+
+		countArchiveEntry(&result, hdr.Name, hdr.FileInfo())
+	}
+	return result, nil
+}
+
+/* Calculate the uncompressed size of the files in a ZIP file from its central
+ * directory, without extracting it
+ * Parameters:
+ *  - ctx: Cancelling this aborts the read
+ *  - p: Path to a .zip file
+ * Returns:
+ *  - (dirResult, error): Total size of the regular files in the archive and the
+ *    number of them counted, or an error if the archive couldn't be read or has
+ *    encrypted entries
+ */
+func zipSize(ctx context.Context, p string) (dirResult, error) {
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+			return dirResult{}, err
+		}
+		return dirResult{}, fmt.Errorf("not a valid ZIP archive: %w", err)
+	}
+	defer zr.Close()
+
+	result := dirResult{Path: p}
+	for _, f := range zr.File {
+		if err := ctx.Err(); err != nil {
+			return dirResult{}, err
+		}
+		// archive/zip can't decrypt entries, so their sizes can't be trusted either
+		if f.Flags&0x1 != 0 {
+			return dirResult{}, fmt.Errorf("encrypted ZIP archives aren't supported (%s is encrypted)", f.Name)
+		}
+		countArchiveEntry(&result, f.Name, f.FileInfo())
+	}
+	return result, nil
+}
+
+/* Count an archive entry towards the archive's total if it passes the filters
+ * Parameters:
+ *  - result: The archive's result so far, updated in place
+ *  - name: The entry's name as stored in the archive
+ *  - info: The entry's file info
+ */
+func countArchiveEntry(result *dirResult, name string, info fs.FileInfo) {
+	name = path.Clean(strings.TrimPrefix(name, "/"))
+	if !info.Mode().IsRegular() || !wantArchiveEntry(name) {
+		return
+	}
+	entry := filepath.Join(result.Path, filepath.FromSlash(name))
+	if !wantFile(entry, info) {
+		return
+	}
+	size := fileSize(info)
+	result.Size += size
+	result.Files++
+	recordFile(entry, info, size)
+	recordDepth(strings.Count(name, "/"), size)
+	recordTop(result.Path, name, size)
+	if progressFlag {
+		progressFiles.Add(1)
+		progressBytes.Add(size)
+	}
+}
+
+/* Check an archive entry against -recursive, -depth, -no-recurse-into and the
+ * exclusions, as if the archive were a directory
+ * Parameters:
+ *  - name: The entry's cleaned path inside the archive, with "/" separators
+ * Returns:
+ *  - bool: true if the entry should be counted
+ */
+func wantArchiveEntry(name string) bool {
+	parts := strings.Split(name, "/")
+	if dirs := len(parts) - 1; dirs > 0 && (!recursiveFlag || (depthFlag >= 0 && dirs > depthFlag)) {
+		return false
+	}
+	// Excluding a directory excludes everything inside it
+	for i, part := range parts {
+		if isExcluded(part) {
+			return false
+		}
+		if i < len(parts)-1 && noRecurseInto(part) {
+			return false
+		}
+		rel := strings.Join(parts[:i+1], "/")
+		for _, re := range excludeRegexps {
+			if re.MatchString(rel) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The number of characters inside each -bar, or 0 without -bar
+var barWidth int
+
+/* Get the width of the terminal the output goes to
+ * Parameters:
+ *  - w: The output
+ * Returns:
+ *  - int: The terminal's width in columns if w is one, otherwise $COLUMNS, or 80
+ *    if that isn't set either
+ */
+func outputColumns(w io.Writer) int {
+	if f, ok := w.(*os.File); ok && isTerminal(f) {
+		_, cols := terminalSize()
+		return cols
+	}
+	if cols, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && cols > 0 {
+		return cols
+	}
+	return 80
+}
+
+/* Work out how wide to draw the bars for -bar
+ * Parameters:
+ *  - cols: The width of the output in columns
+ * Returns:
+ *  - int: A third of the width, leaving the rest for the paths and sizes, but
+ *    at least 10 and at most 60 characters
+ */
+func barWidthFor(cols int) int {
+	return min(max(cols/3, 10), 60)
+}
+
+/* Draw a directory's share of the grand total for -bar
+ * Parameters:
+ *  - size: The directory's size
+ *  - total: The cumulative size of all directories
+ * Returns:
+ *  - string: The bar and a space, like "[####      ] ", to go at the start of the
+ *    line, or "" without -bar
+ */
+func formatBar(size, total int64) string {
+	if barWidth == 0 {
+		return ""
+	}
+	filled := 0
+	if total > 0 {
+		filled = min(int(float64(size)/float64(total)*float64(barWidth)+0.5), barWidth)
+	}
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(" ", barWidth-filled) + "] "
+}
+
+/* Leave room for a bar on a line that doesn't have one, such as the total's
+ * Returns:
+ *  - string: Spaces as wide as a bar from formatBar, or "" without -bar
+ */
+func barPadding() string {
+	if barWidth == 0 {
+		return ""
+	}
+	return strings.Repeat(" ", barWidth+3)
+}
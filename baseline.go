@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// One argument's sizes in a -baseline file
+type baselineEntry struct {
+	sizes   map[string]int64 // The argument's size and, with a tree, every subdirectory's, by path
+	hasTree bool
+}
+
+// A previous -json result to compare against, for -baseline
+type baseline struct {
+	args  []string // In the order they were listed
+	byArg map[string]baselineEntry
+	total *int64 // Left out if the baseline was written with -quiet
+}
+
+// The baseline from -baseline, or nil if it isn't set
+var sizeBaseline *baseline
+
+/* Load a previous -json result
+ * Parameters:
+ *	- name: Path of the file
+ * Returns:
+ *	- (*baseline, error): The sizes in it, or an error if it couldn't be read or
+ *	  isn't -json output.  Directories that failed in that run are left out.
+ */
+func loadBaseline(name string) (*baseline, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var report jsonReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if report.SchemaVersion != jsonSchemaVersion {
+		return nil, fmt.Errorf("%s: expected -json output with schema version %d, got %d", name, jsonSchemaVersion, report.SchemaVersion)
+	}
+	b := &baseline{byArg: make(map[string]baselineEntry), total: report.Total}
+	for _, r := range report.Directories {
+		if r.Error != "" {
+			continue
+		}
+		b.args = append(b.args, r.Path)
+		b.byArg[r.Path] = resultSizes(r)
+	}
+	return b, nil
+}
+
+/* Get the sizes in a result to compare with a baseline
+ * Parameters:
+ *	- r: The result
+ * Returns:
+ *	- baselineEntry: The argument's size and, if it has a tree, its subdirectories'
+ */
+func resultSizes(r dirResult) baselineEntry {
+	e := baselineEntry{sizes: map[string]int64{r.Path: r.Size}, hasTree: r.Tree != nil}
+	if r.Tree != nil {
+		flattenTree(r.Tree, r.Path, e.sizes)
+	}
+	return e
+}
+
+/* Print how each directory has changed since the baseline, after the totals
+ * Parameters:
+ *	- b: The baseline
+ *	- results: Per-directory results from this run
+ *	- total: The cumulative totals from this run
+ */
+func printBaselineChanges(b *baseline, results []dirResult, total dirResult) {
+	printHeading("Changes since baseline:")
+	measured := make(map[string]bool)
+	grown := 0
+	for _, r := range results {
+		if r.Error != "" {
+			continue
+		}
+		measured[r.Path] = true
+		before, ok := b.byArg[r.Path]
+		if !ok {
+			printRecord(fmt.Sprintf("%s: %s (added)", r.Path, formatDelta(r.Size)))
+			continue
+		}
+		after := resultSizes(r)
+		// Subdirectories can only be compared if both runs have them
+		if !before.hasTree || !after.hasTree {
+			before.sizes = map[string]int64{r.Path: before.sizes[r.Path]}
+			after.sizes = map[string]int64{r.Path: r.Size}
+		}
+		var paths []string
+		for p := range before.sizes {
+			paths = append(paths, p)
+		}
+		for p := range after.sizes {
+			if _, ok := before.sizes[p]; !ok {
+				paths = append(paths, p)
+			}
+		}
+		sort.Strings(paths)
+		for _, p := range paths {
+			old, inBefore := before.sizes[p]
+			size, inAfter := after.sizes[p]
+			switch {
+			case !inBefore:
+				printRecord(fmt.Sprintf("%s: %s (added)", p, formatDelta(size)))
+			case !inAfter:
+				printRecord(fmt.Sprintf("%s: %s (removed)", p, formatDelta(-old)))
+			case size != old:
+				line, over := baselineChange(p, old, size)
+				if over {
+					grown++
+				}
+				printRecord(line)
+			}
+		}
+	}
+	for _, arg := range b.args {
+		if !measured[arg] {
+			printRecord(fmt.Sprintf("%s: %s (removed)", arg, formatDelta(-b.byArg[arg].sizes[arg])))
+		}
+	}
+	if b.total != nil && !quietFlag {
+		printRecord(fmt.Sprintf("%s: %s", totalLabelFlag, formatDelta(total.Size-*b.total)))
+	}
+	if grown > 0 {
+		fmt.Fprintf(os.Stderr, "%d directories grew by more than %g%% since the baseline\n", grown, baselineGrowthFlag)
+	}
+}
+
+/* Describe a directory's change in size since the baseline
+ * Parameters:
+ *	- p: The directory's path
+ *	- old: Its size in the baseline
+ *	- size: Its size now
+ * Returns:
+ *	- (string, bool): The line to print, and true if it grew by more than
+ *	  -baseline-growth
+ */
+func baselineChange(p string, old, size int64) (string, bool) {
+	if old == 0 {
+		// There's no percentage to grow by from nothing, but it's still growth
+		return fmt.Sprintf("%s: %s (was empty)", p, formatDelta(size)), size > 0
+	}
+	pct := float64(size-old) / float64(old) * 100
+	line := fmt.Sprintf("%s: %s (%+.1f%%)", p, formatDelta(size-old), pct)
+	if pct > baselineGrowthFlag {
+		return line + " over threshold", true
+	}
+	return line, false
+}
@@ -0,0 +1,97 @@
+package main
+
+import "sort"
+
+// Total bytes and file count for one group of files
+type groupTotal struct {
+	Key   string `json:"key"`
+	Size  int64  `json:"size"`
+	Files int64  `json:"files"`
+}
+
+// Aggregates file sizes by an arbitrary key, such as the file extension
+type breakdown map[string]*groupTotal
+
+/* Add a file to its group
+ * Parameters:
+ *	- key: The group the file belongs to
+ *	- size: The size the file contributed to the total
+ */
+func (b breakdown) add(key string, size int64) {
+	g, ok := b[key]
+	if !ok {
+		g = &groupTotal{Key: key}
+		b[key] = g
+	}
+	g.Size += size
+	g.Files++
+}
+
+/* Get every group, largest first
+ * Returns:
+ *	- []groupTotal: The groups, sorted by size or with -breakdown-sort count by
+ *	  file count, descending, and then by key.  With -merge-below the small
+ *	  groups are merged into one last group.
+ */
+func (b breakdown) sorted() []groupTotal {
+	groups := make([]groupTotal, 0, len(b))
+	for _, g := range b {
+		groups = append(groups, *g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if breakdownSortFlag == "count" {
+			if groups[i].Files != groups[j].Files {
+				return groups[i].Files > groups[j].Files
+			}
+		} else if groups[i].Size != groups[j].Size {
+			return groups[i].Size > groups[j].Size
+		}
+		return groups[i].Key < groups[j].Key
+	})
+	return mergeSmallGroups(groups)
+}
+
+/* Merge the groups with less than -merge-below percent of the total into one,
+ * keyed "(other)"
+ * Parameters:
+ *	- groups: The groups, in the order to print them
+ * Returns:
+ *	- []groupTotal: The groups that are big enough, in the same order, then the
+ *	  merged group if there is one.  The share is of the bytes, or of the files
+ *	  with -breakdown-sort count.
+ */
+func mergeSmallGroups(groups []groupTotal) []groupTotal {
+	if mergeBelowFlag <= 0 {
+		return groups
+	}
+	measure := func(g groupTotal) int64 {
+		if breakdownSortFlag == "count" {
+			return g.Files
+		}
+		return g.Size
+	}
+	var total int64
+	for _, g := range groups {
+		total += measure(g)
+	}
+	var kept []groupTotal
+	other := groupTotal{Key: "(other)"}
+	merged := 0
+	for _, g := range groups {
+		if total > 0 && float64(measure(g))/float64(total)*100 >= mergeBelowFlag {
+			kept = append(kept, g)
+			continue
+		}
+		other.Size += g.Size
+		other.Files += g.Files
+		merged++
+	}
+	// A single small group is clearer under its own name
+	if merged == 1 {
+		return groups
+	}
+	if merged > 0 {
+		kept = append(kept, other)
+	}
+	return kept
+}
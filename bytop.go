@@ -0,0 +1,81 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// One argument's totals by the entry directly inside it that files are under,
+// for -by-top
+type topBreakdown struct {
+	Path   string       `json:"path"`
+	Groups []groupTotal `json:"groups"`
+}
+
+// Each argument's totals for -by-top, keyed by topKey
+var byTop map[string]breakdown
+
+/* Find the key an argument's -by-top totals are kept under
+ * Parameters:
+ *	- arg: The argument as given
+ * Returns:
+ *	- string: The argument cleaned as the walk cleans it, or as given if it's a URL
+ */
+func topKey(arg string) string {
+	if isRemote(arg) {
+		return arg
+	}
+	return filepath.Clean(arg)
+}
+
+/* Find the group a file falls in for -by-top
+ * Parameters:
+ *	- rel: The file's path below the argument, separated by slashes or the
+ *	  platform's separator
+ * Returns:
+ *	- string: The name of the entry directly inside the argument that the file
+ *	  is under, or "(root)" if it's directly inside the argument itself
+ */
+func topComponent(rel string) string {
+	first, _, found := strings.Cut(filepath.ToSlash(rel), "/")
+	if !found {
+		return "(root)"
+	}
+	return first
+}
+
+/* Note a counted file for -by-top
+ * Parameters:
+ *	- arg: The argument being measured
+ *	- rel: The file's path below the argument
+ *	- size: The size the file contributed to the argument
+ */
+func recordTop(arg, rel string, size int64) {
+	if byTop == nil {
+		return
+	}
+	reportMu.Lock()
+	defer reportMu.Unlock()
+	key := topKey(arg)
+	if byTop[key] == nil {
+		byTop[key] = make(breakdown)
+	}
+	byTop[key].add(topComponent(rel), size)
+}
+
+/* Get each argument's -by-top totals
+ * Parameters:
+ *	- results: Per-directory results, in the order to report them
+ * Returns:
+ *	- []topBreakdown: One per argument with any files counted, its groups
+ *	  largest first
+ */
+func topBreakdowns(results []dirResult) []topBreakdown {
+	var tops []topBreakdown
+	for _, r := range results {
+		if b := byTop[topKey(r.Path)]; b != nil && (r.Error == "" || r.Partial) {
+			tops = append(tops, topBreakdown{Path: r.Path, Groups: b.sorted()})
+		}
+	}
+	return tops
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The flags that change what a directory measures as.  A cache written with
+// different values for any of them is thrown away.
+var cachedOptionFlags = []string{
+	"recursive", "depth", "exclude", "no-recurse-into", "exclude-regexp", "exclude-hidden", "include", "ignore-case", "normalize-unicode", "type",
+	"count-links", "disk-usage", "block-size", "follow-symlinks", "follow-dirs", "follow-files", "follow-top-level", "max-symlink-depth", "one-file-system", "exclude-device",
+	"min-size", "max-size", "newer-than", "older-than", "exclude-newer", "exclude-older", "gitignore", "filter-cmd", "sample",
+	"count-dirs", "dereference-count",
+}
+
+// A directory's measurements, as of the modification times they were taken at
+type cacheEntry struct {
+	ModTime          time.Time            `json:"modTime"`
+	Size             int64                `json:"size"`
+	Files            int64                `json:"files"`
+	Dirs             int64                `json:"dirs,omitempty"`             // With -count-dirs
+	Entries          int64                `json:"entries,omitempty"`          // With -count-dirs
+	DereferencedSize *int64               `json:"dereferencedSize,omitempty"` // With -dereference-count
+	Estimated        bool                 `json:"estimated,omitempty"`        // With -sample
+	DirTimes         map[string]time.Time `json:"dirTimes,omitempty"`         // Every directory walked, by path relative to the argument
+}
+
+// Directory sizes from previous runs, for -cache
+type walkCache struct {
+	Options string                `json:"options"`
+	Entries map[string]cacheEntry `json:"entries"`
+	mu      sync.Mutex
+}
+
+// The cache from -cache, or nil if it isn't set
+var dirCache *walkCache
+
+/* Check whether anything besides the size and file count is wanted from the walk
+ * Returns:
+ *	- bool: true if a per-file report, -tree, -strict-symlinks,
+ *	  -dedupe-across-args, -pattern-stats or -save is enabled
+ */
+func needsWalk() bool {
+	return treeFlag || topDirsFlag > 0 || strictSymlinksFlag || dedupeAcrossArgsFlag || patternStatsFlag || saveFlag != "" || manifestFlag != "" || verifyFlag != "" || perFileReports()
+}
+
+/* Describe the current values of the flags that affect measurements
+ * Returns:
+ *	- string: The flags and their values, to compare against a cache's
+ */
+func cacheOptions() string {
+	var opts []string
+	for _, name := range cachedOptionFlags {
+		opts = append(opts, name+"="+flag.Lookup(name).Value.String())
+	}
+	return strings.Join(opts, " ")
+}
+
+/* Load the cache from a previous run
+ * Parameters:
+ *	- name: Path of the cache file
+ * Returns:
+ *	- *walkCache: The cached entries, or an empty cache if the file is missing, is
+ *	  corrupt or was written with different options
+ */
+func loadCache(name string) *walkCache {
+	c := &walkCache{Options: cacheOptions(), Entries: make(map[string]cacheEntry)}
+	data, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c
+	}
+	var prev walkCache
+	if err == nil {
+		err = json.Unmarshal(data, &prev)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring cache %s: %v\n", name, err)
+		return c
+	}
+	if prev.Options == c.Options && prev.Entries != nil {
+		c.Entries = prev.Entries
+	}
+	return c
+}
+
+/* Write the cache for the next run
+ * Parameters:
+ *	- name: Path of the cache file
+ * Returns:
+ *	- error: An error if the file couldn't be written
+ */
+func (c *walkCache) save(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(data, '\n'), 0o644)
+}
+
+/* Measure a directory, reusing its cached size if it hasn't been modified
+ * Parameters:
+ *	- ctx: Cancelling this stops the walk
+ *	- path: Path to the directory or file
+ * Returns:
+ *	- (dirResult, error): The same as measurePath
+ */
+func (c *walkCache) measurePath(ctx context.Context, path string) (dirResult, error) {
+	// Without a key or modification time, just measure it
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return measurePath(ctx, path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return measurePath(ctx, path)
+	}
+
+	// The reports that look at individual files and -tree need the walk, so the
+	// cached size is only refreshed for them
+	c.mu.Lock()
+	e, ok := c.Entries[abs]
+	c.mu.Unlock()
+	if ok && e.fresh(path, info) && !needsWalk() {
+		return dirResult{
+			Path: path, Size: e.Size, Files: e.Files, IsFile: info.Mode().IsRegular(),
+			Dirs: e.Dirs, Entries: e.Entries, DereferencedSize: e.DereferencedSize, Estimated: e.Estimated,
+		}, nil
+	}
+
+	result, err := measurePath(ctx, path)
+	if err != nil {
+		return result, err
+	}
+	c.mu.Lock()
+	c.Entries[abs] = cacheEntry{
+		ModTime: info.ModTime(), Size: result.Size, Files: result.Files,
+		Dirs: result.Dirs, Entries: result.Entries, DereferencedSize: result.DereferencedSize, Estimated: result.Estimated,
+		DirTimes: result.dirTimes,
+	}
+	c.mu.Unlock()
+	result.dirTimes = nil
+	return result, nil
+}
+
+/* Check whether a cached entry still holds.  Adding, removing or renaming an
+ * entry only changes the modification time of the directory it is in, so every
+ * directory the walk went into is checked, not just the argument.
+ * Parameters:
+ *	- path: Path to the directory or file
+ *	- info: What it is now
+ * Returns:
+ *	- bool: true if neither it nor any directory walked below it has been
+ *	  modified since the entry was made
+ */
+func (e cacheEntry) fresh(path string, info fs.FileInfo) bool {
+	if !e.ModTime.Equal(info.ModTime()) {
+		return false
+	}
+	for rel, mtime := range e.DirTimes {
+		dir, err := os.Stat(filepath.Join(path, filepath.FromSlash(rel)))
+		if err != nil || !dir.ModTime().Equal(mtime) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+/* Measure an argument through the cache, then save the cache and load it back,
+ * as the next run would see it
+ * Parameters:
+ *	- t: The test
+ *	- name: Path of the cache file
+ *	- dir: The argument
+ * Returns:
+ *	- dirResult: The argument's result
+ */
+func measureCached(t *testing.T, name, dir string) dirResult {
+	t.Helper()
+	dirCache = loadCache(name)
+	t.Cleanup(func() { dirCache = nil })
+	result, err := dirCache.measurePath(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dirCache.save(name); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+/* Set the modification time of every directory in a tree to the same moment
+ * in the past, so that any change made afterwards shows
+ * Parameters:
+ *	- t: The test
+ *	- root: The top of the tree
+ */
+func backdateDirs(t *testing.T, root string) {
+	t.Helper()
+	old := time.Now().Add(-time.Hour)
+	err := filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		return os.Chtimes(p, old, old)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCacheChecksNestedDirectories(t *testing.T) {
+	setFlags(t, "-recursive")
+	dir := filepath.Join(t.TempDir(), "archive")
+	writeTree(t, dir, map[string]string{"a.txt": "aaaa", "x/y/b.txt": "bb"})
+	backdateDirs(t, dir)
+	name := filepath.Join(t.TempDir(), "cache.json")
+
+	if got := measureCached(t, name, dir); got.Size != 6 || got.Files != 2 {
+		t.Fatalf("first run = %d bytes in %d files, want 6 in 2", got.Size, got.Files)
+	}
+	// Rewriting a file in place changes no directory, so the cached size is used
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("aaaaaaaa"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := measureCached(t, name, dir); got.Size != 6 {
+		t.Fatalf("unchanged directories measured %d bytes, want the cached 6", got.Size)
+	}
+	// A file added deep inside only changes x/y, which has to be noticed
+	if err := os.WriteFile(filepath.Join(dir, "x", "y", "c.txt"), []byte("ccc"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := measureCached(t, name, dir); got.Size != 13 || got.Files != 3 {
+		t.Fatalf("after a change in x/y = %d bytes in %d files, want 13 in 3", got.Size, got.Files)
+	}
+	// So does a nested directory being removed
+	if err := os.RemoveAll(filepath.Join(dir, "x", "y")); err != nil {
+		t.Fatal(err)
+	}
+	if got := measureCached(t, name, dir); got.Size != 8 || got.Files != 1 {
+		t.Fatalf("after removing x/y = %d bytes in %d files, want 8 in 1", got.Size, got.Files)
+	}
+}
+
+func TestCacheHitKeepsCounts(t *testing.T) {
+	for _, args := range [][]string{
+		{"-count-dirs"},
+		{"-dereference-count"},
+		{"-recursive", "-count-dirs", "-dereference-count"},
+	} {
+		t.Run(strings.Join(args, " "), func(t *testing.T) {
+			setFlags(t, args...)
+			root := t.TempDir()
+			dir := filepath.Join(root, "archive")
+			writeTree(t, dir, map[string]string{"a.txt": "aaaa", "x/b.txt": "bb"})
+			writeTree(t, root, map[string]string{"outside.txt": strings.Repeat("o", 100)})
+			if err := os.Symlink("../outside.txt", filepath.Join(dir, "link")); err != nil {
+				t.Fatal(err)
+			}
+			backdateDirs(t, dir)
+			name := filepath.Join(t.TempDir(), "cache.json")
+
+			want := measureCached(t, name, dir)
+			// Rewritten in place, so only a walk would see the new size
+			if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("aaaaaaaa"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got := measureCached(t, name, dir)
+			if got.Size != want.Size {
+				t.Fatalf("second run measured %d bytes, want the cached %d", got.Size, want.Size)
+			}
+			if got.Dirs != want.Dirs || got.Entries != want.Entries {
+				t.Errorf("cached run = %d dirs, %d entries, want %d, %d", got.Dirs, got.Entries, want.Dirs, want.Entries)
+			}
+			if countDirsFlag && want.Entries == 0 {
+				t.Errorf("-count-dirs counted no entries")
+			}
+			if (got.DereferencedSize == nil) != (want.DereferencedSize == nil) ||
+				got.DereferencedSize != nil && *got.DereferencedSize != *want.DereferencedSize {
+				t.Errorf("cached run's dereferenced size = %v, want %v", got.DereferencedSize, want.DereferencedSize)
+			}
+			if dereferenceCountFlag && (want.DereferencedSize == nil || *want.DereferencedSize <= want.Size) {
+				t.Errorf("-dereference-count measured %v, want more than the %d bytes without following links", want.DereferencedSize, want.Size)
+			}
+		})
+	}
+}
+
+func TestCacheDiscardedWithNewCountFlags(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "archive")
+	writeTree(t, dir, map[string]string{"a.txt": "aaaa", "b.txt": "bb"})
+	backdateDirs(t, dir)
+	name := filepath.Join(t.TempDir(), "cache.json")
+
+	setFlags(t)
+	measureCached(t, name, dir)
+	// An entry made without -count-dirs has no counts to give
+	setFlags(t, "-count-dirs")
+	if got := measureCached(t, name, dir); got.Dirs != 1 || got.Entries != 3 {
+		t.Errorf("-count-dirs after a run without it = %d dirs, %d entries, want 1, 3", got.Dirs, got.Entries)
+	}
+}
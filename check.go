@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+/* Check whether an argument is the top of a filesystem, so its total should
+ * account for most of the filesystem's used space
+ * Parameters:
+ *  - p: The argument
+ * Returns:
+ *  - bool: true if p is on a different device from its parent, or is "/"
+ */
+func isMountPoint(p string) bool {
+	canon := canonicalPath(p)
+	info, err := os.Stat(canon)
+	if err != nil {
+		return false
+	}
+	parent, err := os.Stat(filepath.Dir(canon))
+	if err != nil {
+		return false
+	}
+	dev, ok := deviceID(info)
+	parentDev, parentOK := deviceID(parent)
+	return ok && parentOK && (dev != parentDev || os.SameFile(info, parent))
+}
+
+/* Warn about arguments whose total is much smaller than the space their
+ * filesystem reports as used, which usually means the walk missed files, for
+ * -check
+ * Parameters:
+ *  - results: Per-directory results
+ */
+func checkTotals(results []dirResult) {
+	for _, r := range results {
+		if r.Error != "" || r.Overlaps != "" || r.IsFile {
+			continue
+		}
+		// Anything below the top of a filesystem is expected to be smaller than it
+		if !isMountPoint(r.Path) {
+			fmt.Fprintf(os.Stderr, "Warning: -check skipped %s, which isn't the top of a filesystem\n", r.Path)
+			continue
+		}
+		used, err := usedSpace(r.Path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: -check couldn't get the space used on %s: %v\n", r.Path, err)
+			continue
+		}
+		if used <= 0 {
+			continue
+		}
+		if ratio := float64(r.Size) / float64(used); ratio < checkToleranceFlag {
+			fmt.Fprintf(os.Stderr, "Warning: %s measured %s, only %.0f%% of the %s its filesystem reports as used; the walk may have missed files\n",
+				r.Path, formatSize(r.Size), ratio*100, formatSize(used))
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// The flags that change what a result holds, besides those that change what it
+// measures as.  A checkpoint written with different values is thrown away.
+var checkpointOptionFlags = []string{"tree", "report-depth", "count-dirs", "dereference-count"}
+
+// The arguments finished so far in a run, for -checkpoint
+type checkpoint struct {
+	Options   string               `json:"options"`
+	Completed map[string]dirResult `json:"completed"` // By absolute path
+	name      string
+	mu        sync.Mutex
+}
+
+// The checkpoint from -checkpoint, or nil if it isn't set
+var runCheckpoint *checkpoint
+
+/* Describe the current values of the flags that affect results
+ * Returns:
+ *	- string: The flags and their values, to compare against a checkpoint's
+ */
+func checkpointOptions() string {
+	opts := cacheOptions()
+	for _, name := range checkpointOptionFlags {
+		opts += " " + name + "=" + flag.Lookup(name).Value.String()
+	}
+	return opts
+}
+
+/* Load the checkpoint left by an earlier, unfinished run
+ * Parameters:
+ *	- name: Path of the checkpoint file
+ * Returns:
+ *	- *checkpoint: The finished arguments, or none if the file is missing, is
+ *	  corrupt or was written with different options
+ */
+func loadCheckpoint(name string) *checkpoint {
+	c := &checkpoint{Options: checkpointOptions(), Completed: make(map[string]dirResult), name: name}
+	data, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c
+	}
+	var prev checkpoint
+	if err == nil {
+		err = json.Unmarshal(data, &prev)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring checkpoint %s: %v\n", name, err)
+		return c
+	}
+	if prev.Options != c.Options {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring checkpoint %s: it was written with different options\n", name)
+		return c
+	}
+	if prev.Completed != nil {
+		c.Completed = prev.Completed
+	}
+	return c
+}
+
+/* Get the key an argument is checkpointed under
+ * Parameters:
+ *	- p: The argument
+ * Returns:
+ *	- string: Its absolute path, or p itself for remote arguments or if it
+ *	  can't be made absolute
+ */
+func checkpointKey(p string) string {
+	if isRemote(p) {
+		return p
+	}
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return p
+}
+
+/* Get the result of an argument an earlier run already finished
+ * Parameters:
+ *	- p: The argument
+ * Returns:
+ *	- (dirResult, bool): The result, with the path as given this time, and
+ *	  false if the argument hasn't been finished
+ */
+func (c *checkpoint) lookup(p string) (dirResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, ok := c.Completed[checkpointKey(p)]
+	if ok {
+		slog.Debug("reusing checkpointed result", "path", p, "checkpoint", c.name)
+	}
+	r.Path = p
+	return r, ok
+}
+
+/* Record a finished argument and write the checkpoint, so a later run can skip it
+ * Parameters:
+ *	- p: The argument
+ *	- r: Its result
+ * Returns:
+ *	- error: An error if the checkpoint couldn't be written
+ */
+func (c *checkpoint) record(p string, r dirResult) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Completed[checkpointKey(p)] = r
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	// Write a new file and rename it over the old, so dying part way through a
+	// write doesn't lose the checkpoint
+	tmp := c.name + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.name)
+}
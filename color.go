@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// ANSI escape sequences for -color
+const (
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiRed    = "\x1b[31m"
+	ansiReset  = "\x1b[0m"
+)
+
+// Whether sizes in text output are colorized, decided once flags are parsed
+var colorEnabled bool
+
+/* Decide whether to colorize output for a -color mode
+ * Parameters:
+ *	- mode: "auto", "always" or "never"
+ *	- w: Where the output is going
+ * Returns:
+ *	- bool: true if sizes should be colorized.  "auto" only colorizes when w is a
+ *	  terminal.
+ */
+func useColor(mode string, w io.Writer) bool {
+	switch mode {
+	case "always":
+		return true
+	case "auto":
+		f, ok := w.(*os.File)
+		return ok && isTerminal(f)
+	}
+	return false
+}
+
+/* Check whether a file is a terminal
+ * Parameters:
+ *	- f: The file to check
+ * Returns:
+ *	- bool: true if f is a character device, as terminals are
+ */
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+/* Wrap a formatted size in a color reflecting its magnitude
+ * Parameters:
+ *	- size: Size in bytes
+ *	- s: The formatted size
+ * Returns:
+ *	- string: s in green, yellow from -color-medium, or red from -color-large
+ */
+func colorize(size int64, s string) string {
+	color := ansiGreen
+	switch {
+	case size >= int64(colorLargeFlag):
+		color = ansiRed
+	case size >= int64(colorMediumFlag):
+		color = ansiYellow
+	}
+	return color + s + ansiReset
+}
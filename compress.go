@@ -0,0 +1,155 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"os"
+	"sort"
+)
+
+const (
+	compressionSampleFiles = 256       // Most files compressed for -estimate-compression
+	compressionWindow      = 128 << 10 // Most bytes read from each sampled file
+)
+
+// The result of -estimate-compression
+type compressionEstimate struct {
+	SampledFiles    int64   `json:"sampledFiles"`
+	SampledBytes    int64   `json:"sampledBytes"`    // Bytes read from the sampled files
+	CompressedBytes int64   `json:"compressedBytes"` // What those bytes compressed to
+	Ratio           float64 `json:"ratio"`           // CompressedBytes / SampledBytes
+	EstimatedSize   int64   `json:"estimatedSize"`   // The total with the ratio applied
+}
+
+// Picks a uniform random sample of the counted files during the walk, without
+// keeping every path, for -estimate-compression
+type compressionSampler struct {
+	seen      int64    // Files that could have been sampled
+	seenBytes int64    // The size they contributed to the total
+	sample    []string // At most compressionSampleFiles of them
+}
+
+/* Offer a counted file to the sample
+ * Parameters:
+ *	- p: Path of the file
+ *	- size: The size the file contributed to the total
+ */
+func (c *compressionSampler) add(p string, size int64) {
+	c.seen++
+	c.seenBytes += size
+	// Reservoir sampling: the nth file replaces a sampled one with probability
+	// compressionSampleFiles/n, which leaves every file equally likely to be kept
+	if len(c.sample) < compressionSampleFiles {
+		c.sample = append(c.sample, p)
+	} else if i := rand.Int64N(c.seen); i < compressionSampleFiles {
+		c.sample[i] = p
+	}
+}
+
+/* Compress the sample and extrapolate its ratio to the total
+ * Parameters:
+ *	- total: The total size of everything counted
+ * Returns:
+ *	- compressionEstimate: The estimate.  Files that couldn't be sampled, like
+ *	  archive entries, are assumed not to compress.
+ */
+func (c *compressionSampler) estimate(total int64) compressionEstimate {
+	paths := append([]string(nil), c.sample...)
+	sort.Strings(paths)
+	read, compressed := compressFiles(paths)
+
+	est := compressionEstimate{Ratio: 1, EstimatedSize: total}
+	for i := range paths {
+		if read[i] >= 0 {
+			est.SampledFiles++
+			est.SampledBytes += read[i]
+			est.CompressedBytes += compressed[i]
+		}
+	}
+	if est.SampledBytes > 0 {
+		est.Ratio = float64(est.CompressedBytes) / float64(est.SampledBytes)
+		est.EstimatedSize = total - c.seenBytes + int64(math.Round(float64(c.seenBytes)*est.Ratio))
+	}
+	return est
+}
+
+/* Compress the start of each file using a pool of -jobs workers
+ * Parameters:
+ *	- paths: The files to compress
+ * Returns:
+ *	- ([]int64, []int64): The bytes read from each file and what they compressed
+ *	  to, in the same order as paths, with -1 read for files that couldn't be
+ *	  read.  Those are reported on stderr, also in order.
+ */
+func compressFiles(paths []string) ([]int64, []int64) {
+	read := make([]int64, len(paths))
+	compressed := make([]int64, len(paths))
+	errs := make([]error, len(paths))
+	forEachParallel(len(paths), jobsFlag, func(i int) {
+		read[i], compressed[i], errs[i] = compressFile(paths[i])
+	})
+
+	for i, err := range errs {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error sampling %s: %v\n", paths[i], err)
+			read[i] = -1
+		}
+	}
+	return read, compressed
+}
+
+// Counts what is written to it, so compressed output doesn't need to be kept
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+/* Gzip the first compressionWindow bytes of a file at the fastest level
+ * Parameters:
+ *	- p: Path of the file
+ * Returns:
+ *	- (int64, int64, error): The bytes read and the size they compressed to, or
+ *	  an error if the file couldn't be read
+ */
+func compressFile(p string) (int64, int64, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var out countingWriter
+	gz, err := gzip.NewWriterLevel(&out, gzip.BestSpeed)
+	if err != nil {
+		return 0, 0, err
+	}
+	n, err := io.Copy(gz, io.LimitReader(f, compressionWindow))
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := gz.Close(); err != nil {
+		return 0, 0, err
+	}
+	return n, out.n, nil
+}
+
+/* Print the compression estimate after the totals
+ * Parameters:
+ *	- est: The estimate
+ */
+func printCompressionEstimate(est compressionEstimate) {
+	printHeading("Compression estimate:")
+	if est.SampledFiles == 0 {
+		printRecord("No files could be sampled")
+		return
+	}
+	printRecord(fmt.Sprintf("Estimated gzip size: %s (%.1f%% of the total)", formatSize(est.EstimatedSize), est.Ratio*100))
+	printRecord(fmt.Sprintf("Sampled: %d files, %s read", est.SampledFiles, formatSize(est.SampledBytes)))
+}
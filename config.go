@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Shorthand flags and the flags they stand for, so a config file value doesn't
+// override a shorthand given on the command line
+var flagAliases = map[string]string{"s": "summary", "L": "follow-top-level"}
+
+/* Find the config file to read defaults from
+ * Parameters:
+ *	- name: The -config value, or "" for the default
+ * Returns:
+ *	- (string, bool): The path, and true if it was asked for explicitly, so it
+ *	  has to exist.  The path is "" if there is no home directory to look in.
+ */
+func configPath(name string) (string, bool) {
+	if name != "" {
+		return name, true
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", false
+	}
+	return filepath.Join(home, ".hellofordrc"), false
+}
+
+/* Apply the flag values in a config file to the flags that weren't given on the
+ * command line
+ * Parameters:
+ *	- name: Path of the config file, a JSON object mapping flag names (without the
+ *	  leading dash) to values.  Repeatable flags take an array.
+ *	- required: Whether a missing file is an error rather than being ignored
+ * Returns:
+ *	- error: An error if the file couldn't be read or parsed, or names a flag
+ *	  that doesn't exist or a value it rejects
+ */
+func loadConfig(name string, required bool) error {
+	data, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	values := make(map[string]any)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	// Keep numbers as written, so sizes like 1048576 aren't turned into 1.048576e+06
+	dec.UseNumber()
+	if err := dec.Decode(&values); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+		if long, ok := flagAliases[f.Name]; ok {
+			set[long] = true
+		}
+	})
+
+	// Apply them in a fixed order, so errors don't depend on map iteration
+	names := make([]string, 0, len(values))
+	for n := range values {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		f := flag.Lookup(n)
+		if f == nil || n == "config" {
+			return fmt.Errorf("%s: unknown flag %q", name, n)
+		}
+		if set[n] {
+			continue
+		}
+		elems, ok := values[n].([]any)
+		if !ok {
+			elems = []any{values[n]}
+		}
+		for _, v := range elems {
+			var s string
+			switch v := v.(type) {
+			case string:
+				s = v
+			case bool, json.Number:
+				s = fmt.Sprint(v)
+			default:
+				return fmt.Errorf("%s: value for %q must be a string, number, boolean or array of them", name, n)
+			}
+			if err := f.Value.Set(s); err != nil {
+				return fmt.Errorf("%s: invalid value %q for %q: %w", name, s, n, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import "strconv"
+
+// Totals for files grouped by how many directories below the argument they are,
+// for -by-depth
+type depthBreakdown struct {
+	groups []groupTotal // One per depth, starting with the files directly in an argument
+}
+
+/* Add a file to the group for its depth
+ * Parameters:
+ *	- depth: How many directories below the argument the file's directory is,
+ *	  0 for a file directly inside it
+ *	- size: The size the file contributed to the total
+ */
+func (b *depthBreakdown) add(depth int, size int64) {
+	// Depths that only hold directories still get a row, so the table shows the
+	// whole shape of the tree
+	for len(b.groups) <= depth {
+		b.groups = append(b.groups, groupTotal{Key: strconv.Itoa(len(b.groups))})
+	}
+	b.groups[depth].Size += size
+	b.groups[depth].Files++
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+/* Flatten a tree into the size of every directory in it
+ * Parameters:
+ *	- n: The root of the tree
+ *	- rel: The root's path relative to the top of the tree, "." for the top
+ *	- sizes: Filled in with each directory's size, by relative path
+ */
+func flattenTree(n *dirNode, rel string, sizes map[string]int64) {
+	sizes[rel] = n.Size
+	for _, c := range n.Children {
+		flattenTree(c, filepath.Join(rel, filepath.Base(c.Path)), sizes)
+	}
+}
+
+/* Format a change in size, with its sign
+ * Parameters:
+ *	- delta: The change in bytes
+ * Returns:
+ *	- string: The change, like "+1.5 KB" or "-200 bytes"
+ */
+func formatDelta(delta int64) string {
+	if delta < 0 {
+		return "-" + formatSize(-delta)
+	}
+	return "+" + formatSize(delta)
+}
+
+/* Compare two trees directory by directory, for -diff
+ * Parameters:
+ *	- ctx: Cancelling this stops the walks
+ *	- before: The directory to compare against
+ *	- after: The directory to compare
+ * Returns:
+ *	- bool: false if either directory couldn't be measured
+ */
+func runDiff(ctx context.Context, before, after string) bool {
+	results := measureDirectories(ctx, []string{before, after})
+	if len(results) != 2 {
+		return false
+	}
+	sizes := make([]map[string]int64, 2)
+	for i, r := range results {
+		if r.Error != "" {
+			printError(r)
+			return false
+		}
+		sizes[i] = make(map[string]int64)
+		if r.Tree != nil {
+			flattenTree(r.Tree, ".", sizes[i])
+		}
+	}
+
+	var paths []string
+	for rel := range sizes[0] {
+		paths = append(paths, rel)
+	}
+	for rel := range sizes[1] {
+		if _, ok := sizes[0][rel]; !ok {
+			paths = append(paths, rel)
+		}
+	}
+	sort.Strings(paths)
+
+	// The argument itself is reported as the total, last
+	for _, rel := range paths {
+		if rel == "." {
+			continue
+		}
+		old, inBefore := sizes[0][rel]
+		size, inAfter := sizes[1][rel]
+		switch {
+		case !inBefore:
+			printRecord(fmt.Sprintf("%s: %s (added)", rel, formatDelta(size)))
+		case !inAfter:
+			printRecord(fmt.Sprintf("%s: %s (removed)", rel, formatDelta(-old)))
+		case size != old:
+			printRecord(fmt.Sprintf("%s: %s", rel, formatDelta(size-old)))
+		}
+	}
+	printRecord(fmt.Sprintf("%s: %s", totalLabelFlag, formatDelta(results[1].Size-results[0].Size)))
+	return true
+}
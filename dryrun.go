@@ -0,0 +1,22 @@
+package main
+
+import "sync"
+
+// Keeps -dry-run lines from different workers from interleaving
+var dryRunMu sync.Mutex
+
+/* Print one line of the -dry-run listing
+ * Parameters:
+ *	- verdict: "included" or "excluded"
+ *	- p: The entry's path as reached from the argument
+ *	- rule: The rule that excluded the entry, or "" if it's included
+ */
+func printDryRun(verdict, p, rule string) {
+	line := verdict + "\t" + p
+	if rule != "" {
+		line += "\t" + rule
+	}
+	dryRunMu.Lock()
+	defer dryRunMu.Unlock()
+	printRecord(line)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// A set of files with identical content
+type dupeGroup struct {
+	Hash        string   `json:"sha256"`
+	Size        int64    `json:"size"` // Size of each copy
+	Paths       []string `json:"paths"`
+	Reclaimable int64    `json:"reclaimable"` // Space freed by keeping only one copy
+}
+
+// Collects files by size during the walk, so only files that share a size with
+// another need to be hashed
+type dupeFinder struct {
+	bySize map[int64][]string
+}
+
+/* Create an empty duplicate finder
+ * Returns:
+ *	- *dupeFinder: A finder with no files
+ */
+func newDupeFinder() *dupeFinder {
+	return &dupeFinder{bySize: make(map[int64][]string)}
+}
+
+/* Record a file as a candidate duplicate
+ * Parameters:
+ *	- p: Path of the file
+ *	- size: The file's apparent size
+ */
+func (d *dupeFinder) add(p string, size int64) {
+	// Empty files are all identical, but there's no space to reclaim from them
+	if size > 0 {
+		d.bySize[size] = append(d.bySize[size], p)
+	}
+}
+
+/* Hash the candidates and group the ones with identical content
+ * Returns:
+ *	- []dupeGroup: Groups of two or more identical files, most reclaimable space
+ *	  first.  Files that can't be read are reported on stderr and left out.
+ */
+func (d *dupeFinder) groups() []dupeGroup {
+	// Only files that share their size with another could be duplicates
+	var candidates []string
+	sizes := make(map[string]int64)
+	for size, paths := range d.bySize {
+		if len(paths) < 2 {
+			continue
+		}
+		for _, p := range paths {
+			candidates = append(candidates, p)
+			sizes[p] = size
+		}
+	}
+	sort.Strings(candidates)
+	sums := hashFiles(candidates)
+
+	type key struct {
+		size int64
+		sum  string
+	}
+	same := make(map[key][]string)
+	for i, p := range candidates {
+		if sums[i] != "" {
+			k := key{sizes[p], sums[i]}
+			same[k] = append(same[k], p)
+		}
+	}
+	var groups []dupeGroup
+	for k, paths := range same {
+		if len(paths) < 2 {
+			continue
+		}
+		groups = append(groups, dupeGroup{
+			Hash:        k.sum,
+			Size:        k.size,
+			Paths:       paths,
+			Reclaimable: k.size * int64(len(paths)-1),
+		})
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Reclaimable != groups[j].Reclaimable {
+			return groups[i].Reclaimable > groups[j].Reclaimable
+		}
+		return groups[i].Paths[0] < groups[j].Paths[0]
+	})
+	return groups
+}
+
+/* Hash files using a pool of -jobs workers
+ * Parameters:
+ *	- paths: The files to hash
+ * Returns:
+ *	- []string: Each file's hash, in the same order as paths, or "" for files that
+ *	  couldn't be read.  Those are reported on stderr, also in order.
+ */
+func hashFiles(paths []string) []string {
+	sums := make([]string, len(paths))
+	errs := make([]error, len(paths))
+	forEachParallel(len(paths), jobsFlag, func(i int) {
+		sums[i], errs[i] = hashFile(paths[i])
+	})
+
+	for i, err := range errs {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error hashing %s: %v\n", paths[i], err)
+			sums[i] = ""
+		}
+	}
+	return sums
+}
+
+/* Compute the SHA-256 of a file's content, streaming it rather than reading it
+ * into memory
+ * Parameters:
+ *	- p: Path of the file
+ * Returns:
+ *	- (string, error): The hex-encoded hash, or an error if the file couldn't be read
+ */
+func hashFile(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/* Count the entries a walk of a directory would reach, reading the directory
+ * listings but without statting any files, for -estimate
+ * Parameters:
+ *  - ctx: Cancelling this stops the count
+ *  - path: Path to the directory
+ * Returns:
+ *  - (int64, error): The entries reached, the directory itself included, or an
+ *    error if the count was cut short
+ */
+func countEntries(ctx context.Context, path string) (int64, error) {
+	w, err := newWalker(ctx, path)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	err = fs.WalkDir(os.DirFS(path), ".", func(rel string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		p := filepath.Join(w.root, filepath.FromSlash(rel))
+		// The walk skips what it can't read, so the count does too
+		if err != nil {
+			if os.IsPermission(err) && p != w.root {
+				return nil
+			}
+			return err
+		}
+		// Prune the tree exactly as the walk would, as far as that can be told
+		// from names and directory types alone
+		if p != w.root && w.exclusion(p, d.IsDir()) != "" {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		n++
+		if !d.IsDir() {
+			return nil
+		}
+		if !w.descend(p) || (p != w.root && noRecurseInto(d.Name())) || !w.sameFileSystem(p, d) {
+			return filepath.SkipDir
+		}
+		if w.ignore != nil {
+			return w.ignore.load(w.absPath(p))
+		}
+		return nil
+	})
+	return n, err
+}
+
+/* Print how many entries measuring the arguments would reach and roughly how
+ * long it would take, without measuring them, for -estimate
+ * Parameters:
+ *  - ctx: Cancelling this stops the count
+ *  - dirs: The arguments
+ * Returns:
+ *  - bool: false if any argument couldn't be counted
+ */
+func printEstimate(ctx context.Context, dirs []string) bool {
+	ok := true
+	start := time.Now()
+	var total int64
+	for _, dir := range dirs {
+		var n int64
+		var err error
+		switch info, statErr := os.Stat(dir); {
+		case isRemote(dir) || isArchive(dir):
+			fmt.Fprintf(os.Stderr, "Not estimating %s: archives and remote directories are only read by a real run\n", dir)
+			continue
+		case statErr != nil:
+			err = statErr
+		case !info.IsDir():
+			n = 1
+		default:
+			n, err = countEntries(ctx, dir)
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error counting %s: %v\n", dir, err)
+			ok = false
+			continue
+		}
+		total += n
+		printRecord(fmt.Sprintf("%s: %s entries", dir, formatCount(n)))
+	}
+	eta := time.Duration(float64(total) / float64(estimateRateFlag) * float64(time.Second))
+	if eta >= time.Minute {
+		eta = eta.Round(time.Second)
+	} else {
+		eta = eta.Round(10 * time.Millisecond)
+	}
+	printRecord(fmt.Sprintf("%s: %s entries, about %s to measure at %s entries/s (counted in %s)", totalLabelFlag, formatCount(total),
+		eta, formatCount(int64(estimateRateFlag)), time.Since(start).Round(time.Millisecond)))
+	return ok
+}
+
+/* Format a count for text output, honouring -comma
+ * Parameters:
+ *  - n: The count
+ * Returns:
+ *  - string: The count, with its digits grouped by -comma
+ */
+func formatCount(n int64) string {
+	if commaFlag {
+		return groupDigits(n)
+	}
+	return fmt.Sprint(n)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// A file reported by -extremes
+type extremeFile struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// The oldest and largest file seen so far, for -extremes.  Unlike -top and -stats
+// this only keeps two files, however many are walked.
+type extremes struct {
+	Oldest  *extremeFile `json:"oldest,omitempty"`
+	Largest *extremeFile `json:"largest,omitempty"`
+}
+
+/* Record a counted file
+ * Parameters:
+ *	- p: Path of the file
+ *	- size: The size the file contributed to the total
+ *	- mtime: The file's modification time
+ */
+func (e *extremes) add(p string, size int64, mtime time.Time) {
+	f := extremeFile{Path: p, Size: size, ModTime: mtime}
+	// Break ties on the path so runs are repeatable
+	if o := e.Oldest; o == nil || mtime.Before(o.ModTime) || (mtime.Equal(o.ModTime) && p < o.Path) {
+		e.Oldest = &f
+	}
+	if l := e.Largest; l == nil || size > l.Size || (size == l.Size && p < l.Path) {
+		e.Largest = &f
+	}
+}
+
+/* Print the oldest and largest file after the totals
+ * Parameters:
+ *	- e: The files found
+ */
+func printExtremes(e *extremes) {
+	printHeading("Extremes:")
+	if e.Oldest == nil {
+		printRecord("No files")
+		return
+	}
+	printRecord(fmt.Sprintf("Oldest: %s: %s", e.Oldest.Path, formatTime(e.Oldest.ModTime)))
+	printRecord(fmt.Sprintf("Largest: %s: %s", e.Largest.Path, formatSize(e.Largest.Size)))
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// A long-running -filter-cmd process for -filter-stream, which is sent one path
+// per line on stdin and answers each with a line on stdout
+type filterProcess struct {
+	mu  sync.Mutex // Held for each question and answer, since walks run concurrently
+	cmd *exec.Cmd
+	in  io.WriteCloser
+	out *bufio.Reader
+}
+
+// The process from -filter-cmd with -filter-stream, or nil if it isn't set
+var filterProc *filterProcess
+
+/* Split the -filter-cmd command line into the program and its arguments
+ * Parameters:
+ *  - command: The command, split on spaces with no shell quoting
+ * Returns:
+ *  - ([]string, error): The program and arguments, or an error if the command
+ *    is blank or the program can't be found
+ */
+func filterArgs(command string) ([]string, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, errors.New("no command given")
+	}
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+/* Start the filter process for -filter-stream
+ * Parameters:
+ *  - command: The -filter-cmd command line
+ * Returns:
+ *  - (*filterProcess, error): The running process, or an error if it couldn't
+ *    be started
+ */
+func startFilterProcess(command string) (*filterProcess, error) {
+	args, err := filterArgs(command)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &filterProcess{cmd: cmd, in: in, out: bufio.NewReader(out)}, nil
+}
+
+/* Ask the filter process about a file
+ * Parameters:
+ *  - p: Path of the file
+ * Returns:
+ *  - (bool, error): true if the process answered "y", false if "n", or an error
+ *    if it answered anything else or stopped answering
+ */
+func (f *filterProcess) accepts(p string) (bool, error) {
+	if strings.ContainsAny(p, "\n\r") {
+		return false, fmt.Errorf("can't send %q to -filter-stream: it contains a line break", p)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := io.WriteString(f.in, p+"\n"); err != nil {
+		return false, err
+	}
+	line, err := f.out.ReadString('\n')
+	if err != nil {
+		return false, fmt.Errorf("no answer for %s: %w", p, err)
+	}
+	switch strings.TrimSpace(line) {
+	case "y":
+		return true, nil
+	case "n":
+		return false, nil
+	}
+	return false, fmt.Errorf("answered %q for %s, expected y or n", strings.TrimSpace(line), p)
+}
+
+/* Tell the filter process there are no more files and wait for it to exit
+ * Returns:
+ *  - error: An error if it exited unsuccessfully
+ */
+func (f *filterProcess) close() error {
+	f.in.Close()
+	return f.cmd.Wait()
+}
+
+/* Check a file with -filter-cmd
+ * Parameters:
+ *  - p: Path of the file
+ * Returns:
+ *  - bool: true if the command accepts the file.  Without -filter-stream it is
+ *    run with the path as its last argument and accepts it by exiting with
+ *    status 0.  A filter that can't be run at all ends the program, since every
+ *    total would be wrong.
+ */
+func filterCmdAccepts(p string) bool {
+	if filterProc != nil {
+		ok, err := filterProc.accepts(p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error running -filter-cmd: %v\n", err)
+			exitDuringRun(exitFailure)
+		}
+		return ok
+	}
+	args, _ := filterArgs(filterCmdFlag)
+	cmd := exec.Command(args[0], append(args[1:], p)...)
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		fmt.Fprintf(os.Stderr, "Error running -filter-cmd: %v\n", err)
+		exitDuringRun(exitFailure)
+	}
+	return err == nil
+}
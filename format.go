@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// The fields a -format or -format-total template can use
+type formatFields struct {
+	Path    string  // The directory, or the -total-label
+	Bytes   int64   // Size in bytes
+	Human   string  // Size in human-readable form, honouring -si
+	Files   int64   // Regular files counted
+	Percent float64 // Share of the cumulative total, from 0 to 100
+}
+
+// The parsed -format and -format-total templates, or nil to use the default layout
+var lineTemplate, totalTemplate *template.Template
+
+/* Parse a -format or -format-total template, and check that it only uses fields
+ * that exist
+ * Parameters:
+ *	- name: The flag the template came from, for error messages
+ *	- text: The template
+ * Returns:
+ *	- (*template.Template, error): The template, or an error if it is invalid
+ */
+func parseFormat(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	// Unknown fields are only reported when the template runs
+	if err := t.Execute(io.Discard, formatFields{}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+/* Format one line of text output with a template
+ * Parameters:
+ *	- t: The template
+ *	- r: The result to format
+ *	- total: The cumulative size of all directories
+ * Returns:
+ *	- string: The expanded template
+ */
+func formatTemplate(t *template.Template, r dirResult, total int64) string {
+	fields := formatFields{
+		Path:  r.Path,
+		Bytes: r.Size,
+		Human: humanReadableSize(r.Size, unitBase(), unitLabels()),
+		Files: r.Files,
+	}
+	if total > 0 {
+		fields.Percent = float64(r.Size) / float64(total) * 100
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, fields); err != nil {
+		// The template was checked at startup, so this shouldn't happen
+		return fmt.Sprintf("%s: %v", r.Path, err)
+	}
+	return b.String()
+}
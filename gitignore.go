@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// A single pattern from a .gitignore file
+type ignoreRule struct {
+	base     string   // Absolute path of the directory the pattern is relative to
+	segments []string // The pattern split on "/"
+	anchored bool     // Matches relative to base rather than against any base name
+	dirOnly  bool     // Only matches directories (the pattern ended in "/")
+	negate   bool     // Re-includes a previously ignored path (the pattern began with "!")
+}
+
+// The .gitignore patterns that apply to one walk, in the order they were loaded
+type gitIgnore struct {
+	rules []ignoreRule
+}
+
+/* Set up .gitignore handling for a path, if it's inside a git repository
+ * Parameters:
+ *	- abs: Absolute path of the argument being walked
+ * Returns:
+ *	- (*gitIgnore, error): The patterns from the repository's .git/info/exclude
+ *	  and every .gitignore from the repository root down to the argument's parent,
+ *	  or nil if the path isn't in a repository
+ */
+func newGitIgnore(abs string) (*gitIgnore, error) {
+	// Find the repository root, collecting the directories above the argument
+	var dirs []string
+	root := abs
+	for {
+		if _, err := os.Lstat(filepath.Join(root, ".git")); err == nil {
+			break
+		}
+		parent := filepath.Dir(root)
+		if parent == root {
+			return nil, nil
+		}
+		root = parent
+		dirs = append(dirs, root)
+	}
+
+	g := &gitIgnore{}
+	if err := g.loadFile(filepath.Join(root, ".git", "info", "exclude"), root); err != nil {
+		return nil, err
+	}
+	// Load from the top down so that deeper files take precedence.  The argument's
+	// own .gitignore is loaded when the walk reaches it.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := g.load(dirs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+/* Load the .gitignore in a directory, if there is one
+ * Parameters:
+ *	- dir: Absolute path of the directory
+ * Returns:
+ *	- error: An error if the file exists but couldn't be read
+ */
+func (g *gitIgnore) load(dir string) error {
+	return g.loadFile(filepath.Join(dir, ".gitignore"), dir)
+}
+
+/* Load the patterns from a gitignore-format file
+ * Parameters:
+ *	- name: Path of the file
+ *	- base: Absolute path of the directory the patterns are relative to
+ * Returns:
+ *	- error: An error if the file exists but couldn't be read
+ */
+func (g *gitIgnore) loadFile(name, base string) error {
+	f, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreRule(scanner.Text(), base); ok {
+			g.rules = append(g.rules, rule)
+		}
+	}
+	return scanner.Err()
+}
+
+/* Parse one line of a .gitignore file
+ * Parameters:
+ *	- line: The line, without its newline
+ *	- base: Absolute path of the directory the pattern is relative to
+ * Returns:
+ *	- (ignoreRule, bool): The rule, and false for blank lines and comments
+ */
+func parseIgnoreRule(line, base string) (ignoreRule, bool) {
+	// Trailing spaces are ignored unless escaped with a backslash
+	if !strings.HasSuffix(line, "\\ ") {
+		line = strings.TrimRight(line, " ")
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+	line = normalizeName(line)
+
+	rule := ignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	// A slash anywhere but the end ties the pattern to the .gitignore's directory
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+	rule.segments = strings.Split(line, "/")
+	return rule, true
+}
+
+/* Check whether a path is ignored
+ * Parameters:
+ *	- abs: Absolute path of the entry
+ *	- isDir: Whether the entry is a directory
+ * Returns:
+ *	- bool: true if the last pattern matching the path ignores it
+ */
+func (g *gitIgnore) ignored(abs string, isDir bool) bool {
+	ignored := false
+	for _, rule := range g.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.matches(abs) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+/* Check whether a rule's pattern matches a path
+ * Parameters:
+ *	- abs: Absolute path of the entry
+ * Returns:
+ *	- bool: true if the path matches, regardless of negation
+ */
+func (r ignoreRule) matches(abs string) bool {
+	rel, err := filepath.Rel(r.base, abs)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	parts := strings.Split(normalizeName(filepath.ToSlash(rel)), "/")
+	if !r.anchored {
+		matched, _ := path.Match(r.segments[0], parts[len(parts)-1])
+		return matched
+	}
+	return matchSegments(r.segments, parts)
+}
+
+/* Match path components against pattern components, where "**" matches any
+ * number of components
+ * Parameters:
+ *	- pattern: The pattern split on "/"
+ *	- parts: The path split on "/"
+ * Returns:
+ *	- bool: true if the whole path matches the whole pattern
+ */
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			pattern = pattern[1:]
+			// A trailing "/**" matches everything inside, but not the directory itself
+			if len(pattern) == 0 {
+				return len(parts) > 0
+			}
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern, parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pattern[0], parts[0]); !matched {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
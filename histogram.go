@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Width of the longest bar -histogram draws
+const histogramBarWidth = 40
+
+// One size range of the -histogram report
+type histogramBucket struct {
+	Label string `json:"label"`
+	Files int64  `json:"files"`
+	Size  int64  `json:"size"`
+}
+
+// Counts files in fixed size ranges: under 1K, 1K up to 1M, 1M up to 1G, and 1G
+// and over
+type histogram struct {
+	unit   int64
+	counts [4]histogramBucket
+}
+
+/* Create an empty histogram
+ * Parameters:
+ *	- unit: The unit base the ranges are scaled by, 1024 or 1000
+ *	- labels: The unit names to label the ranges with
+ * Returns:
+ *	- *histogram: A histogram with labelled but empty buckets
+ */
+func newHistogram(unit int64, labels sizeLabels) *histogram {
+	p, b := labels.prefixes, labels.suffix
+	h := &histogram{unit: unit}
+	h.counts[0].Label = fmt.Sprintf("< 1 %c%s", p[0], b)
+	h.counts[1].Label = fmt.Sprintf("1 %c%s - 1 %c%s", p[0], b, p[1], b)
+	h.counts[2].Label = fmt.Sprintf("1 %c%s - 1 %c%s", p[1], b, p[2], b)
+	h.counts[3].Label = fmt.Sprintf(">= 1 %c%s", p[2], b)
+	return h
+}
+
+/* Count a file in its bucket
+ * Parameters:
+ *	- size: The size the file contributed to the total
+ */
+func (h *histogram) add(size int64) {
+	i := 0
+	if size >= h.unit {
+		_, exp := unitScale(size, h.unit)
+		i = min(exp+1, len(h.counts)-1)
+	}
+	h.counts[i].Files++
+	h.counts[i].Size += size
+}
+
+/* Get the buckets, smallest range first
+ * Returns:
+ *	- []histogramBucket: Every bucket, including empty ones
+ */
+func (h *histogram) buckets() []histogramBucket {
+	return append([]histogramBucket(nil), h.counts[:]...)
+}
+
+/* Print the histogram after the totals, with a bar per bucket scaled to the
+ * fullest one
+ * Parameters:
+ *	- buckets: The buckets to print
+ */
+func printHistogram(buckets []histogramBucket) {
+	var most int64
+	for _, b := range buckets {
+		most = max(most, b.Files)
+	}
+
+	printHeading("Histogram:")
+	for _, b := range buckets {
+		bar := ""
+		if most > 0 {
+			bar = strings.Repeat("#", int(b.Files*histogramBarWidth/most))
+		}
+		printRecord(fmt.Sprintf("%-13s %8d files %12s  %s", b.Label, b.Files, formatSize(b.Size), bar))
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A directory open in the -interactive browser
+type browseLevel struct {
+	node   *dirNode
+	cursor int // The selected subdirectory
+	offset int // The first subdirectory shown, once the list scrolls
+}
+
+/* Build the tree the browser starts at
+ * Parameters:
+ *	- results: Per-directory results
+ * Returns:
+ *	- *dirNode: The one argument's tree, or a node holding every argument's tree
+ *	  under the -label if there are several
+ */
+func browseRoot(results []dirResult) *dirNode {
+	var trees []*dirNode
+	for _, r := range results {
+		if (r.Error != "" && !r.Partial) || r.Overlaps != "" {
+			continue
+		}
+		if r.Tree != nil {
+			trees = append(trees, r.Tree)
+		} else {
+			trees = append(trees, &dirNode{Path: r.Path, Size: r.Size, Files: r.Files})
+		}
+	}
+	if len(trees) == 1 {
+		return trees[0]
+	}
+	root := &dirNode{Path: labelFlag, Children: trees}
+	for _, t := range trees {
+		root.Size += t.Size
+		root.Files += t.Files
+	}
+	root.sortChildren(sortFlag)
+	return root
+}
+
+/* Read a key press from the terminal
+ * Parameters:
+ *	- r: The terminal, in raw mode
+ * Returns:
+ *	- (string, error): "up", "down", "in", "out" or "quit", "" for any other key,
+ *	  or an error if the terminal couldn't be read
+ */
+func readKey(r io.Reader) (string, error) {
+	buf := make([]byte, 8)
+	n, err := r.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	switch string(buf[:n]) {
+	case "\x1b[A", "k":
+		return "up", nil
+	case "\x1b[B", "j":
+		return "down", nil
+	case "\x1b[C", "\r", "l":
+		return "in", nil
+	case "\x1b[D", "\x7f", "\b", "h":
+		return "out", nil
+	case "q", "\x1b", "\x03":
+		return "quit", nil
+	}
+	return "", nil
+}
+
+/* Cut a line of text down to the terminal width
+ * Parameters:
+ *	- s: The line
+ *	- cols: The terminal width
+ * Returns:
+ *	- string: s, shortened if it wouldn't fit
+ */
+func fitLine(s string, cols int) string {
+	if r := []rune(s); len(r) > cols {
+		return string(r[:cols])
+	}
+	return s
+}
+
+/* Draw the directory at the top of the stack
+ * Parameters:
+ *	- w: The terminal
+ *	- level: The directory being shown, whose offset is updated to keep the
+ *	  cursor on screen
+ *	- rows, cols: The terminal size
+ */
+func drawLevel(w io.Writer, level *browseLevel, rows, cols int) {
+	n := level.node
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	b.WriteString(fitLine(fmt.Sprintf("%s: %s (%d files)", n.Path, formatSize(n.Size), n.Files), cols) + "\r\n\r\n")
+
+	// Leave room for the heading and the two footer lines
+	height := max(rows-5, 1)
+	if level.cursor < level.offset {
+		level.offset = level.cursor
+	}
+	if level.cursor >= level.offset+height {
+		level.offset = level.cursor - height + 1
+	}
+
+	var children int64
+	for _, c := range n.Children {
+		children += c.Size
+	}
+	if len(n.Children) == 0 {
+		b.WriteString("(no subdirectories)\r\n")
+	}
+	for i := level.offset; i < len(n.Children) && i < level.offset+height; i++ {
+		c := n.Children[i]
+		share := 0.0
+		if n.Size > 0 {
+			share = float64(c.Size) / float64(n.Size)
+		}
+		bar := strings.Repeat("#", int(share*10+0.5))
+		line := fitLine(fmt.Sprintf("%12s %5.1f%% [%-10s] %s/", formatSize(c.Size), share*100, bar, filepath.Base(c.Path)), cols)
+		if i == level.cursor {
+			line = "\x1b[7m" + line + ansiReset
+		}
+		b.WriteString(line + "\r\n")
+	}
+
+	b.WriteString(fmt.Sprintf("\x1b[%d;1H", rows-1))
+	b.WriteString(fitLine(fmt.Sprintf("%s in files directly in this directory", formatSize(n.Size-children)), cols) + "\r\n")
+	b.WriteString(fitLine("up/down: select  right/enter: open  left/backspace: back  q: quit", cols))
+	io.WriteString(w, b.String())
+}
+
+/* Browse the measured tree in the terminal, for -interactive
+ * Parameters:
+ *	- results: Per-directory results
+ * Returns:
+ *	- error: An error if the terminal couldn't be used
+ */
+func browse(results []dirResult) error {
+	restore, err := enterRawMode()
+	if err != nil {
+		return err
+	}
+	defer restore()
+	// Use the alternate screen, so the shell's scrollback is left as it was
+	fmt.Fprint(os.Stdout, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(os.Stdout, "\x1b[?25h\x1b[?1049l")
+
+	stack := []*browseLevel{{node: browseRoot(results)}}
+	for {
+		level := stack[len(stack)-1]
+		rows, cols := terminalSize()
+		drawLevel(os.Stdout, level, rows, cols)
+
+		key, err := readKey(os.Stdin)
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "up":
+			level.cursor = max(level.cursor-1, 0)
+		case "down":
+			level.cursor = min(level.cursor+1, max(len(level.node.Children)-1, 0))
+		case "in":
+			if len(level.node.Children) > 0 {
+				stack = append(stack, &browseLevel{node: level.node.Children[level.cursor]})
+			}
+		case "out":
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case "quit":
+			return nil
+		}
+	}
+}
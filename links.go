@@ -0,0 +1,76 @@
+package main
+
+import (
+	"slices"
+	"sync"
+)
+
+// A hard-linked file found under one or more arguments
+type sharedLink struct {
+	size int64    // The size it contributed to each argument
+	args []string // The arguments it was counted under, in the order they found it
+}
+
+// Hard-linked files by inode across every argument, for -dedupe-across-args.
+// Each walk still counts a file once per argument; this finds the files more
+// than one argument counted, so the total can count them once.
+type linkTracker struct {
+	mu    sync.Mutex
+	links map[inodeKey]*sharedLink
+}
+
+// The tracker for -dedupe-across-args, or nil if it isn't set
+var crossLinks *linkTracker
+
+/* Create an empty tracker
+ * Returns:
+ *  - *linkTracker: A tracker with no files
+ */
+func newLinkTracker() *linkTracker {
+	return &linkTracker{links: make(map[inodeKey]*sharedLink)}
+}
+
+/* Record a hard-linked file counted under an argument
+ * Parameters:
+ *  - key: The file's inode
+ *  - arg: The argument being measured
+ *  - size: The size the file contributed to it
+ */
+func (t *linkTracker) add(key inodeKey, arg string, size int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l := t.links[key]
+	if l == nil {
+		l = &sharedLink{size: size}
+		t.links[key] = l
+	}
+	if !slices.Contains(l.args, arg) {
+		l.args = append(l.args, arg)
+	}
+}
+
+/* Work out how much of the total was counted more than once
+ * Parameters:
+ *  - counted: The arguments whose sizes make up the total
+ * Returns:
+ *  - (int64, int64): The bytes and files to take off the total so that each file
+ *    shared between counted arguments is only counted once
+ */
+func (t *linkTracker) duplicates(counted map[string]bool) (int64, int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var bytes, files int64
+	for _, l := range t.links {
+		n := int64(0)
+		for _, arg := range l.args {
+			if counted[arg] {
+				n++
+			}
+		}
+		if n > 1 {
+			bytes += l.size * (n - 1)
+			files += n - 1
+		}
+	}
+	return bytes, files
+}
@@ -1,104 +1,3686 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/fs"
+	"log/slog"
+	"math"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // These are our command-line flags
 var humanFlag bool
+var commaFlag bool
+var precisionFlag int
+var unitFlag string
+var siFlag bool
+var iecFlag bool
+var humanShortFlag bool
 var recursiveFlag bool
+var jsonFlag bool
+var ndjsonFlag bool
+var csvFlag bool
+var pathEncodingFlag string
+var summaryFlag bool
+var thresholdFlag thresholdSize
+var ignoreErrorsFlag bool
+var reportInaccessibleFlag bool
+var sortFlag string
+var ignoreCaseFlag bool
+var normalizeUnicodeFlag bool
+var depthFlag int
+var reportDepthFlag int
+var childrenFlag bool
+var excludeFlag stringList
+var noRecurseFlag stringList
+var excludeDeviceFlag stringList
+var filterCmdFlag string
+var filterStreamFlag bool
+var excludeFromFlag stringList
+var includeFlag stringList
+var typeFlag string
+var excludeRegexpFlag stringList
+var countLinksFlag bool
+var dedupeAcrossArgsFlag bool
+var patternStatsFlag bool
+var countFlag bool
+var countDirsFlag bool
+var diskUsageFlag bool
+var followSymlinksFlag bool
+var followDirsFlag bool
+var followFilesFlag bool
+var dereferenceCountFlag bool
+var strictSymlinksFlag bool
+var followTopLevelFlag bool
+var oneFileSystemFlag bool
+var topFlag int
+var topDirsFlag int
+var stdinFlag bool
+var stdin0Flag bool
+var byExtFlag bool
+var byAgeFlag bool
+var byDepthFlag bool
+var byTopFlag bool
+var byMimeFlag bool
+var breakdownSortFlag string
+var mergeBelowFlag float64
+var ageBucketsFlag string
+var byOwnerFlag bool
+var byMountFlag bool
+var emptyFlag bool
+var filesFlag bool
+var sparseFlag bool
+var sparseSummaryFlag bool
+var progressFlag bool
+var gitignoreFlag bool
+var treeFlag bool
+var percentFlag bool
+var barFlag bool
+var alignFlag bool
+var print0Flag bool
+var dupesFlag bool
+var estimateCompressionFlag bool
+var excludeHiddenFlag bool
+var timeFlag bool
+var cpuprofileFlag string
+var memprofileFlag string
+var versionFlag bool
+var printSchemaFlag bool
+var colorFlag string
+var statsFlag bool
+var lowMemoryFlag bool
+var histogramFlag bool
+var extremesFlag bool
+var checkFlag bool
+var epochFlag bool
+var absFlag bool
+var watchFlag time.Duration
+var watchNotifyFlag bool
+var totalFirstFlag bool
+var excludeEmptyFlag bool
+var partialFlag bool
+var maxRuntimeFlag time.Duration
+var sampleFlag int
+var relFlag string
+var interactiveFlag bool
+var checkToleranceFlag float64
+var outputFlag string
+var configFlag string
+var checkpointFlag string
+var baselineFlag string
+var baselineGrowthFlag float64
+var repeatFlag int
+var strictFlag bool
+var dryRunFlag bool
+var rateFlag int
+var maxFilesFlag int64
+var maxSymlinkDepthFlag int
+var retryFlag int
+var quietFlag bool
+var aggregateFlag bool
+var labelFlag string
+var totalLabelFlag string
+var validateFlag bool
+var manifestFlag string
+var verifyFlag string
+var estimateFlag bool
+var estimateRateFlag int
+var saveFlag string
+var loadFlag string
+var mergeFlag bool
+var skipInvalidFlag bool
+var diffFlag bool
+var verboseFlag bool
+var logLevelFlag string
+var formatFlag string
+var formatTotalFlag string
+var cacheFlag string
+
+// Where results are written: stdout, or the -output file.  Errors and progress
+// always go to stderr.
+var output io.Writer = os.Stdout
+var colorMediumFlag = byteSize(1 << 20)
+var colorLargeFlag = byteSize(1 << 30)
+var jobsFlag int
+var parallelArgsLimitFlag int
+var readdirBufferFlag int
+var minSizeFlag byteSize
+var blockSizeFlag byteSize
+var maxSizeFlag byteSize
+var failOverFlag byteSize
+var newerThanFlag timeBound
+var olderThanFlag timeBound
+var excludeNewerFlag timeBound
+var excludeOlderFlag timeBound
+
+// The compiled -exclude-regexp patterns
+var excludeRegexps []*regexp.Regexp
+
+// The largest files seen across all directories, when -top is set
+var largest *topFiles
+
+// The largest subdirectories seen across all directories, when -top-dirs is set
+var largestDirs *topFiles
+
+// The power of the unit base -unit forces every human-readable size into (0 for
+// K, 1 for M, ...), or -1 to pick the best one for each size
+var unitExp = -1
+
+// The extensions -type counts, lower case with a leading dot, or nil to count all
+var typeExts map[string]bool
+
+// Sizes by file extension, when -by-ext is set
+var byExt breakdown
+
+// Files to sniff for their MIME types, when -by-mime is set
+var byMime *mimeSniffer
+
+// Sizes by modification age, when -by-age is set
+var byAge *ageBreakdown
+
+// Totals by directory depth, when -by-depth is set
+var byDepth *depthBreakdown
+
+// The -age-buckets boundaries, parsed
+var ageBounds []time.Duration
+
+// Sizes by file owner, when -by-owner is set
+var byOwner breakdown
+
+// Sizes by mount point, when -by-mount is set
+var byMount breakdown
+
+// Paths skipped because they couldn't be read, across every walk
+var permissionSkips atomic.Int64
+
+// Symlinks whose targets don't exist, found with -strict-symlinks
+var danglingLinks atomic.Int64
+
+// Linked directories not walked because -max-symlink-depth was reached
+var deepLinks atomic.Int64
+
+// Files visited so far across every walk, for -max-files
+var visitedFiles atomic.Int64
+
+// Empty files and directories, when -empty is set
+var emptyPaths []string
+
+// Every counted file, when -files is set
+var listedFiles []fileEntry
+
+// Sparse files, when -sparse is set
+var sparseFiles *sparseFinder
+
+// The apparent and allocated totals of every file, when -sparse-summary is set
+var sparseTotals *sparseSummary
+
+// Candidate duplicate files, when -dupes is set
+var duplicates *dupeFinder
+
+// Sample of files to compress, when -estimate-compression is set
+var compressionSample *compressionSampler
+
+// Every file's size, when -stats is set
+var fileStats *sizeStats
+
+// Files bucketed by size, when -histogram is set
+var sizeHistogram *histogram
+
+// The oldest and largest file, when -extremes is set
+var extremeFiles *extremes
+
+// Guards the per-file reports above, which every worker feeds into
+var reportMu sync.Mutex
+
+// A flag that can be repeated, collecting every value into a slice
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// Exit statuses
+const (
+	exitFailure      = 1   // At least one directory couldn't be processed
+	exitUsage        = 2   // The command line was invalid, as the flag package also exits for flags it can't parse
+	exitOverBudget   = 3   // The total was larger than -fail-over
+	exitInaccessible = 4   // Unreadable paths were skipped, with -report-inaccessible
+	exitTimedOut     = 124 // The run was cut short by -max-runtime, as timeout(1) exits
+	exitInterrupted  = 130 // The run was cut short by SIGINT
+)
+
+// Set once the total has been found to exceed -fail-over
+var overBudget bool
+
+// Version of the -json output format.  Bump this whenever the structure changes.
+const jsonSchemaVersion = 1
+
+// The outcome of measuring a single directory
+type dirResult struct {
+	Path  string   `json:"path"`
+	Size  int64    `json:"size"`
+	Files int64    `json:"files"`
+	Error string   `json:"error,omitempty"`
+	Tree  *dirNode `json:"tree,omitempty"`
+
+	// Directories, and entries of every kind, walked with -count-dirs
+	Dirs    int64 `json:"dirs,omitempty"`
+	Entries int64 `json:"entries,omitempty"`
+
+	// The argument was a regular file rather than a directory
+	IsFile bool `json:"file,omitempty"`
+
+	// The size counting symlinks as what they point to, with -dereference-count
+	DereferencedSize *int64 `json:"dereferencedSize,omitempty"`
+
+	// The argument that already counts this one, which leaves it out of the total
+	Overlaps string `json:"overlaps,omitempty"`
+
+	// The walk hit the Error partway through, and with -partial the sizes are what
+	// it counted before then
+	Partial bool `json:"partial,omitempty"`
+
+	// The sizes were extrapolated from the files -sample picked
+	Estimated bool `json:"estimated,omitempty"`
+
+	// Modification time of every directory walked, by slash-separated path
+	// relative to the argument, for -cache to check the entry against next time
+	dirTimes map[string]time.Time
+}
+
+// The document emitted by -json
+type jsonReport struct {
+	SchemaVersion int                  `json:"schemaVersion"`
+	Directories   []dirResult          `json:"directories"`
+	Total         *int64               `json:"total,omitempty"`      // Left out with -quiet
+	TotalFiles    *int64               `json:"totalFiles,omitempty"` // Left out with -quiet
+	TotalDeref    *int64               `json:"totalDereferenced,omitempty"`
+	TotalDirs     int64                `json:"totalDirs,omitempty"`
+	TotalEntries  int64                `json:"totalEntries,omitempty"`
+	LargestFiles  []fileEntry          `json:"largestFiles,omitempty"`
+	LargestDirs   []fileEntry          `json:"largestDirs,omitempty"`
+	ByExtension   []groupTotal         `json:"byExtension,omitempty"`
+	ByMime        []groupTotal         `json:"byMime,omitempty"`
+	ByAge         []groupTotal         `json:"byAge,omitempty"`
+	ByDepth       []groupTotal         `json:"byDepth,omitempty"`
+	ByTop         []topBreakdown       `json:"byTop,omitempty"`
+	ByOwner       []groupTotal         `json:"byOwner,omitempty"`
+	ByMount       []groupTotal         `json:"byMount,omitempty"`
+	Empty         []string             `json:"empty,omitempty"`
+	SparseFiles   []sparseFile         `json:"sparseFiles,omitempty"`
+	SparseSummary *sparseSummary       `json:"sparseSummary,omitempty"`
+	Duplicates    []dupeGroup          `json:"duplicates,omitempty"`
+	Compression   *compressionEstimate `json:"compressionEstimate,omitempty"`
+	Stats         *statsSummary        `json:"stats,omitempty"`
+	Histogram     []histogramBucket    `json:"histogram,omitempty"`
+	Extremes      *extremes            `json:"extremes,omitempty"`
+	Manifest      *manifestChanges     `json:"manifestChanges,omitempty"` // With -verify
+}
+
+// The names printed for the units of human-readable sizes
+type sizeLabels struct {
+	prefixes string // One prefix character per power of the base, starting at the first
+	suffix   string // What follows the prefix, like the B of KB
+}
+
+var (
+	binaryLabels = sizeLabels{prefixes: "KMGTPE", suffix: "B"}  // KB = 1024 bytes, as the tool has always printed
+	iecLabels    = sizeLabels{prefixes: "KMGTPE", suffix: "iB"} // KiB = 1024 bytes, with -iec
+	siLabels     = sizeLabels{prefixes: "kMGTPE", suffix: "B"}  // kB = 1000 bytes, with -si
+)
 
 /* Convert size to human-readable format
  * Parameters:
  * 	- size: Size in bytes
+ * 	- unit: The unit base, 1024 for binary (KB = 1024 bytes) or 1000 for SI
+ * 	  (kB = 1000 bytes)
+ * 	- labels: The unit names to print, such as binaryLabels or iecLabels for a
+ * 	  base of 1024
  * Returns:
- * 	- string: Human-readable size string
+ * 	- string: Human-readable size string, with -precision decimal places, in
+ * 	  the -human-short style if it is set.  Negative sizes are scaled by their
+ * 	  magnitude.
  */
-func humanReadableSize(size int64) string {
-	const unit = 1024
+func humanReadableSize(size int64, unit int64, labels sizeLabels) string {
+	if unitExp >= 0 {
+		return scaledSize(size, unit, unitExp, labels)
+	}
+	if size < 0 {
+		// The most negative size has no positive counterpart, but a byte less
+		// formats the same
+		return "-" + humanReadableSize(-max(size, -math.MaxInt64), unit, labels)
+	}
 	if size < unit {
+		if humanShortFlag {
+			return strconv.FormatInt(size, 10)
+		}
 		return fmt.Sprintf("%d B", size)
 	}
-	div, exp := int64(unit), 0
-	for n := size / unit; n >= unit; n /= unit {
+	div, exp := unitScale(size, unit)
+	// Rounding can carry into the next unit, turning 1023.96 KB into 1024.0 KB
+	scale := math.Pow(10, float64(precisionFlag))
+	if math.Round(float64(size)/float64(div)*scale)/scale >= float64(unit) && exp < len(unitPrefixes(unit))-1 {
+		exp++
+	}
+	return scaledSize(size, unit, exp, labels)
+}
+
+/* Format a size in a particular unit
+ * Parameters:
+ * 	- size: Size in bytes
+ * 	- unit: The unit base, 1024 or 1000
+ * 	- exp: Which power of the base to use (0 for K, 1 for M, ...)
+ * 	- labels: The unit names to print
+ * Returns:
+ * 	- string: The size in that unit, with -precision decimal places, and with
+ * 	  -human-short just the unit's letter right after the number
+ */
+func scaledSize(size int64, unit int64, exp int, labels sizeLabels) string {
+	value := float64(size)
+	for i := 0; i <= exp; i++ {
+		value /= float64(unit)
+	}
+	if humanShortFlag {
+		return fmt.Sprintf("%.*f%c", precisionFlag, value, labels.prefixes[exp])
+	}
+	return fmt.Sprintf("%.*f %c%s", precisionFlag, value, labels.prefixes[exp], labels.suffix)
+}
+
+/* Format a byte count with thousands separators
+ * Parameters:
+ * 	- size: Size in bytes
+ * Returns:
+ * 	- string: The digits grouped in threes with commas (e.g. 1,234,567)
+ */
+func groupDigits(size int64) string {
+	digits := strconv.FormatInt(size, 10)
+	sign := ""
+	if size < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	var b strings.Builder
+	for i, c := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(c)
+	}
+	return sign + b.String()
+}
+
+/* Get the unit prefixes for a unit base
+ * Parameters:
+ * 	- unit: The unit base, 1024 or 1000
+ * Returns:
+ * 	- string: One prefix character per power of the base, starting at the first
+ */
+func unitPrefixes(unit int64) string {
+	if unit == 1000 {
+		return "kMGTPE"
+	}
+	return "KMGTPE"
+}
+
+/* Find the largest power of the unit base that fits in a size
+ * Parameters:
+ * 	- size: Size in bytes, at least unit
+ * 	- unit: The unit base, 1024 or 1000
+ * Returns:
+ * 	- (int64, int): The power itself, and which one it is (0 for K, 1 for M, ...),
+ * 	  never past the largest prefix
+ */
+func unitScale(size int64, unit int64) (int64, int) {
+	div, exp := unit, 0
+	last := len(unitPrefixes(unit)) - 1
+	for n := size / unit; n >= unit && exp < last; n /= unit {
 		div *= unit
 		exp++
 	}
-	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+	return div, exp
 }
 
-/* Calculate the size of a directory or file
+/* Parse a size such as "500K", "2.5MB" or "4KiB", the inverse of
+ * humanReadableSize
  * Parameters:
- *  - path: Path to the directory or file
+ * 	- s: The size, a number optionally followed by one of K, M, G, T, P or E
+ * 	  (binary multiples of 1024) and an optional "B" or "iB".  Case doesn't
+ * 	  matter.
  * Returns:
- *  - (int64, error): Size of the directory or file, or an error if one occured
+ * 	- (int64, error): Size in bytes, rounded to the nearest byte, or an error if s
+ * 	  isn't a valid size
  */
-func dirSize(path string) (int64, error) {
-	var totalSize int64
-	walkFunc := func(p string, info fs.FileInfo, err error) error {
-		if err != nil {
-			return err
+func parseHumanSize(s string) (int64, error) {
+	const unit = 1024
+	str := strings.ToUpper(strings.TrimSpace(s))
+	str = strings.TrimSuffix(str, "B")
+	if trimmed := strings.TrimSuffix(str, "I"); trimmed != str && trimmed != "" && strings.IndexByte("KMGTPE", trimmed[len(trimmed)-1]) >= 0 {
+		str = trimmed
+	}
+	exp := 0
+	if n := len(str); n > 0 {
+		if i := strings.IndexByte("KMGTPE", str[n-1]); i >= 0 {
+			exp = i + 1
+			str = strings.TrimSpace(str[:n-1])
 		}
-		// If it's a directory and recursion is not enabled, skip subdirectories
-		if info.IsDir() && p != path && !recursiveFlag {
-			return filepath.SkipDir
+	}
+
+	// Only plain decimal numbers, so that ParseFloat's "inf" and hex forms are rejected
+	if str == "" || str == "." || strings.Trim(str, "0123456789.") != "" || strings.Count(str, ".") > 1 {
+		return 0, fmt.Errorf("invalid size %q: expected a number optionally followed by a unit such as K, M or G", s)
+	}
+
+	multiplier := int64(1)
+	for i := 0; i < exp; i++ {
+		multiplier *= unit
+	}
+	tooLarge := fmt.Errorf("invalid size %q: too large", s)
+
+	// Whole numbers are parsed exactly rather than through a float
+	if !strings.Contains(str, ".") {
+		n, err := strconv.ParseInt(str, 10, 64)
+		if err != nil || n > math.MaxInt64/multiplier {
+			return 0, tooLarge
 		}
-		if !info.IsDir() {
-			totalSize += info.Size()
+		return n * multiplier, nil
+	}
+	f, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %v", s, err)
+	}
+	size := math.Round(f * float64(multiplier))
+	if size >= math.MaxInt64 {
+		return 0, tooLarge
+	}
+	return int64(size), nil
+}
+
+// A flag holding a size in bytes, accepting the same units humanReadableSize prints
+type byteSize int64
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(value string) error {
+	size, err := parseHumanSize(value)
+	if err != nil {
+		return err
+	}
+	*b = byteSize(size)
+	return nil
+}
+
+/* Parse a point in time given relative to now or as a timestamp
+ * Parameters:
+ * 	- s: A duration such as "24h", "90m" or "30d" (days) before now, or an RFC3339
+ * 	  timestamp such as "2024-01-02T15:04:05Z"
+ * 	- now: The time durations count back from
+ * Returns:
+ * 	- (time.Time, error): The point in time, or an error if s can't be parsed
+ */
+func parseTimeBound(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err == nil && n >= 0 {
+			return now.Add(-time.Duration(n * float64(24*time.Hour))), nil
 		}
-		return nil
 	}
-	err := filepath.Walk(path, walkFunc)
-	return totalSize, err
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("invalid time %q: expected a duration like 24h or 30d, or an RFC3339 timestamp", s)
+	}
+	return now.Add(-d), nil
 }
 
-/* Print the size of each directory and calculate the cumulative size
+// A flag holding a point in time, unset until given a value
+type timeBound struct {
+	t time.Time
+}
+
+func (b *timeBound) String() string {
+	if b.t.IsZero() {
+		return ""
+	}
+	return b.t.Format(time.RFC3339)
+}
+
+func (b *timeBound) Set(value string) error {
+	t, err := parseTimeBound(value, time.Now())
+	if err != nil {
+		return err
+	}
+	b.t = t
+	return nil
+}
+
+// A flag holding a -threshold size, which may be negative to select directories
+// at or below the size instead of at or above it
+type thresholdSize int64
+
+func (t *thresholdSize) String() string {
+	return strconv.FormatInt(int64(*t), 10)
+}
+
+func (t *thresholdSize) Set(value string) error {
+	size, err := parseHumanSize(strings.TrimPrefix(value, "-"))
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(value, "-") {
+		size = -size
+	}
+	*t = thresholdSize(size)
+	return nil
+}
+
+/* Check whether a directory should be listed under -threshold, like du -t, and
+ * -exclude-empty
  * Parameters:
- *	- dirs: List of directories to process
+ *  - size: The directory's size
+ * Returns:
+ *  - bool: true if size is at least the threshold, or for a negative threshold,
+ *    at most its magnitude.  Always false for an empty directory with
+ *    -exclude-empty.
+ */
+func withinThreshold(size int64) bool {
+	if excludeEmptyFlag && size == 0 {
+		return false
+	}
+	t := int64(thresholdFlag)
+	if t < 0 {
+		return size <= -t
+	}
+	return size >= t
+}
+
+/* Calculate how many levels below root a path is
+ * Parameters:
+ *  - root: The directory the walk started from
+ *  - p: A path at or below root
+ * Returns:
+ *  - int: 0 for root itself, 1 for its direct children, and so on
+ */
+func pathDepth(root, p string) int {
+	rel, err := filepath.Rel(root, p)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+// Identifies a file on disk so hard links to it are only counted once
+type inodeKey struct {
+	dev uint64
+	ino uint64
+}
+
+/* Check whether a path's base name matches any -exclude pattern, or is hidden
+ * with -exclude-hidden
+ * Parameters:
+ *  - p: Path to check
+ * Returns:
+ *  - bool: true if the path should be skipped
+ */
+func isExcluded(p string) bool {
+	return excludedBy(p) != ""
+}
+
+/* Find the rule that excludes a path by its base name
+ * Parameters:
+ *  - p: Path to check
+ * Returns:
+ *  - string: The rule, like "-exclude *.log", or "" if the path isn't excluded
+ */
+func excludedBy(p string) string {
+	base := filepath.Base(p)
+	if excludeHiddenFlag && strings.HasPrefix(base, ".") {
+		return "-exclude-hidden"
+	}
+	if pattern := firstMatch(excludeFlag, base); pattern != "" {
+		return "-exclude " + pattern
+	}
+	return ""
+}
+
+/* Check whether a name matches any of a list of glob patterns
+ * Parameters:
+ *  - patterns: The patterns, already validated
+ *  - name: The base name to match
+ * Returns:
+ *  - bool: true if at least one pattern matches
+ */
+func matchesAny(patterns []string, name string) bool {
+	return firstMatch(patterns, name) != ""
+}
+
+/* Find the first of a list of glob patterns that matches a name
+ * Parameters:
+ *  - patterns: The patterns, already validated
+ *  - name: The base name to match, compared case-insensitively with -ignore-case
+ *    and after NFC normalization with -normalize-unicode
+ * Returns:
+ *  - string: The matching pattern, as given, or "" if none match
+ */
+func firstMatch(patterns []string, name string) string {
+	name = normalizeName(name)
+	if ignoreCaseFlag {
+		name = strings.ToLower(name)
+	}
+	for _, pattern := range patterns {
+		glob := normalizeName(pattern)
+		if ignoreCaseFlag {
+			glob = strings.ToLower(glob)
+		}
+		if matched, _ := filepath.Match(glob, name); matched {
+			return pattern
+		}
+	}
+	return ""
+}
+
+/* Check whether a directory is one of the -no-recurse-into names
+ * Parameters:
+ *  - name: The directory's base name, compared case-insensitively with
+ *    -ignore-case
+ * Returns:
+ *  - bool: true if the walk shouldn't descend into it
+ */
+func noRecurseInto(name string) bool {
+	name = normalizeName(name)
+	return slices.ContainsFunc(noRecurseFlag, func(n string) bool {
+		if ignoreCaseFlag {
+			return strings.EqualFold(normalizeName(n), name)
+		}
+		return normalizeName(n) == name
+	})
+}
+
+/* Normalize a name or pattern before it is matched, with -normalize-unicode
+ * Parameters:
+ *  - s: The name, path or pattern
+ * Returns:
+ *  - string: s in Unicode NFC with -normalize-unicode, so that a name written
+ *    decomposed, as macOS often stores it, matches a composed pattern.
+ *    Otherwise s unchanged.
+ */
+func normalizeName(s string) string {
+	if !normalizeUnicodeFlag {
+		return s
+	}
+	return nfc(s)
+}
+
+/* Get the size a file contributes to the total
+ * Parameters:
+ *  - info: File info from the walk
+ * Returns:
+ *  - int64: The allocated size with -disk-usage, otherwise the apparent size,
+ *    rounded up to a whole number of blocks with -block-size
+ */
+func fileSize(info fs.FileInfo) int64 {
+	size := info.Size()
+	if diskUsageFlag {
+		if allocated, ok := allocatedSize(info); ok {
+			size = allocated
+		}
+	}
+	if bs := int64(blockSizeFlag); bs > 0 {
+		if rem := size % bs; rem != 0 {
+			size += bs - rem
+		}
+	}
+	return size
+}
+
+// State carried through a single dirSize call
+type walker struct {
+	ctx         context.Context      // Cancelled to abort the walk
+	root        string               // The argument being measured
+	size        int64                // Bytes counted so far
+	files       int64                // Regular files counted so far
+	dirs        int64                // Directories seen so far, for -count-dirs
+	entries     int64                // Entries of every kind seen so far, for -count-dirs
+	seen        map[inodeKey]bool    // Hard-linked files already counted
+	visited     map[string]bool      // Real paths of directories already walked, when following symlinks
+	followDirs  bool                 // Walk linked directories, for -follow-dirs
+	followFiles bool                 // Count linked files as their targets, for -follow-files
+	linkDepth   int                  // Symlinks followed to reach the directory being walked
+	estimated   bool                 // Files were left out by -sample
+	estSize     float64              // Bytes the files left out by -sample are estimated to add
+	estFiles    float64              // Files left out by -sample that would have been counted
+	quiet       bool                 // Only count the size, leaving the reports and progress alone
+	dev         uint64               // Device of the argument, with -one-file-system
+	absRoot     string               // Absolute path of the argument, with -gitignore
+	ignore      *gitIgnore           // Patterns from .gitignore files, when in a repository
+	nodes       map[string]*dirNode  // Every directory walked, by path, with -tree
+	only        string               // The one directory to measure, leaving out its subdirectories, with -watch-fsnotify
+	entered     func(string) error   // Called with each directory before it is read, with -watch-fsnotify
+	empty       map[string]bool      // Directories walked that no entry has been seen in yet, with -empty
+	dirTimes    map[string]time.Time // Modification time of every directory walked, relative to the argument, with -cache
+}
+
+/* Calculate the size of an argument, whatever it is
+ * Parameters:
+ *  - ctx: Cancelling this aborts the walk
+ *  - path: Path to the directory or file.  Tar and ZIP archives are measured by their
+ *    contents, and sftp:// URLs over SFTP.
+ * Returns:
+ *  - (dirResult, error): Size of the directory or file and the number of regular
+ *    files counted, or an error if one occured
  */
-func processDirectories(dirs []string) {
-	var cumulativeSize int64
+func measurePath(ctx context.Context, path string) (dirResult, error) {
+	if isRemote(path) {
+		return remoteSize(ctx, path)
+	}
+	if isArchive(path) {
+		return archiveSize(ctx, path)
+	}
+	// A file argument has nothing to walk
+	stat := os.Lstat
+	if followTopLevelFlag {
+		stat = os.Stat
+	}
+	info, err := stat(path)
+	if err == nil && info.Mode().IsRegular() {
+		return measureFile(path, info), nil
+	}
+	// An unreadable argument is skipped like anything else that can't be read
+	if err != nil && !os.IsPermission(err) {
+		return dirResult{}, err
+	}
 
-	for _, dir := range dirs {
-		size, err := dirSize(dir)
+	// os.DirFS always follows a symlinked argument, so one that isn't to be
+	// followed is measured as the link itself, as it would be inside a directory.
+	// With -follow-top-level the link has already been resolved.
+	if err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Stat(path)
+		switch {
+		case err == nil && target.IsDir() && followDirsFlag:
+		case err == nil && !target.IsDir() && followFilesFlag:
+			return measureFile(path, target), nil
+		default:
+			result := measureFile(path, info)
+			if dereferenceCountFlag && err == nil {
+				deref := fileSize(target)
+				if target.IsDir() {
+					if deref, err = dereferencedSize(ctx, os.DirFS(path), path); err != nil {
+						return dirResult{}, err
+					}
+				}
+				result.DereferencedSize = &deref
+			}
+			return result, nil
+		}
+	}
+	return dirSize(ctx, os.DirFS(path), path)
+}
+
+/* Calculate the size of a directory
+ * Parameters:
+ *  - ctx: Cancelling this aborts the walk
+ *  - fsys: The directory, such as os.DirFS(path)
+ *  - path: The name the directory is reported under, which entries' paths are
+ *    built on.  When fsys is on disk this must be its path, which following
+ *    symlinks, -one-file-system and -gitignore rely on.
+ * Returns:
+ *  - (dirResult, error): Size of the directory and the number of regular files
+ *    counted, or an error if one occured.  With -partial, an error that stopped
+ *    the walk comes with what was counted before it.
+ */
+func dirSize(ctx context.Context, fsys fs.FS, path string) (dirResult, error) {
+	w, err := newWalker(ctx, path)
+	if err != nil {
+		return dirResult{}, err
+	}
+	w.followDirs, w.followFiles = followDirsFlag, followFilesFlag
+	if treeFlag || largestDirs != nil {
+		w.nodes = make(map[string]*dirNode)
+	}
+	if emptyFlag {
+		w.empty = make(map[string]bool)
+	}
+	if dirCache != nil {
+		w.dirTimes = make(map[string]time.Time)
+	}
+	// With -partial an error part way through still leaves what was counted
+	walkErr := w.walk(fsys, w.root)
+	if walkErr != nil && (!partialFlag || ctx.Err() != nil) {
+		return dirResult{}, walkErr
+	}
+	result := dirResult{Path: path, Size: w.size, Files: w.files, dirTimes: w.dirTimes}
+	if w.estimated {
+		result.Size += int64(math.Round(w.estSize))
+		result.Files += int64(math.Round(w.estFiles))
+		result.Estimated = true
+	}
+	if countDirsFlag {
+		result.Dirs, result.Entries = w.dirs, w.entries
+	}
+	if dereferenceCountFlag {
+		deref, err := dereferencedSize(ctx, fsys, path)
 		if err != nil {
-			fmt.Printf("Error processing directory %s: %v\n", dir, err)
-			continue
+			return dirResult{}, err
 		}
+		result.DereferencedSize = &deref
+	}
 
-		cumulativeSize += size
-		if humanFlag {
-			fmt.Printf("%s: %s\n", dir, humanReadableSize(size))
-		} else {
-			fmt.Printf("%s: %d bytes\n", dir, size)
+	if len(w.empty) > 0 {
+		reportMu.Lock()
+		for dir := range w.empty {
+			emptyPaths = append(emptyPaths, dir)
 		}
+		reportMu.Unlock()
 	}
 
-	// Output cumulative size
-	if humanFlag {
-		fmt.Printf("Total: %s\n", humanReadableSize(cumulativeSize))
-	} else {
-		fmt.Printf("Total: %d bytes\n", cumulativeSize)
+	if root := w.nodes[w.root]; root != nil {
+		root.Path = path
+		root.rollUp()
+		if largestDirs != nil {
+			offerLargestDirs(root)
+		}
+		if treeFlag {
+			root.sortChildren(sortFlag)
+			if reportDepthFlag >= 0 {
+				root.prune(reportDepthFlag)
+			}
+			result.Tree = root
+		}
 	}
+	return result, walkErr
 }
 
-func main() {
-	// Parse command-line flags
-	flag.BoolVar(&humanFlag, "human", false, "Display sizes in human-readable format (e.g., 1K, 234M, 2G)")
-	flag.BoolVar(&recursiveFlag, "recursive", false, "Recursively calculate the sizes of directories and subdirectories")
-	flag.Parse()
+/* Walk a directory again following every symlink, just for the size, for
+ * -dereference-count
+ * Parameters:
+ *  - ctx: Cancelling this aborts the walk
+ *  - fsys: The directory
+ *  - path: The name the directory is reported under
+ * Returns:
+ *  - (int64, error): The size with symlinks counted as what they point to, or an
+ *    error if one occured
+ */
+func dereferencedSize(ctx context.Context, fsys fs.FS, path string) (int64, error) {
+	d, err := newWalker(ctx, path)
+	if err != nil {
+		return 0, err
+	}
+	d.followDirs, d.followFiles, d.quiet = true, true, true
+	if err := d.walk(fsys, d.root); err != nil {
+		return 0, err
+	}
+	return d.size, nil
+}
 
-	// Remaining command-line arguments are the directories
-	dirs := flag.Args()
+/* Set up the state for walking an argument
+ * Parameters:
+ *  - ctx: Cancelling this aborts the walk
+ *  - path: The argument
+ * Returns:
+ *  - (*walker, error): The walker, or an error if the .gitignore files couldn't be
+ *    loaded
+ */
+func newWalker(ctx context.Context, path string) (*walker, error) {
+	// Walk the cleaned path, so that the root is spelled the same way as the paths
+	// beneath it
+	w := &walker{
+		ctx:     ctx,
+		root:    filepath.Clean(path),
+		seen:    make(map[inodeKey]bool),
+		visited: make(map[string]bool),
+	}
+	if gitignoreFlag {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		w.absRoot = abs
+		if w.ignore, err = newGitIgnore(abs); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
 
-	if len(dirs) == 0 {
-		flag.Usage()
-		os.Exit(1)
+/* Measure a regular file given as an argument
+ * Parameters:
+ *  - path: Path to the file
+ *  - info: File info for the file
+ * Returns:
+ *  - dirResult: The file's size, or nothing if the filters rule it out
+ */
+func measureFile(path string, info fs.FileInfo) dirResult {
+	result := dirResult{Path: path, IsFile: true}
+	if !wantFile(path, info) {
+		return result
+	}
+	result.Size = fileSize(info)
+	// A symlink argument that isn't followed counts towards the size, but isn't a
+	// regular file
+	if info.Mode().IsRegular() {
+		result.Files = 1
+		recordFile(path, info, result.Size)
+		recordLink(path, info, result.Size)
+		recordDepth(0, result.Size)
+		recordTop(path, filepath.Base(path), result.Size)
 	}
+	if progressFlag {
+		progressFiles.Add(1)
+		progressBytes.Add(result.Size)
+	}
+	return result
+}
+
+/* Walk a tree, reporting every entry to visit
+ * Parameters:
+ *  - fsys: The tree to walk
+ *  - logical: The path the top of fsys is reached by from the argument, which may
+ *    pass through followed symlinks
+ * Returns:
+ *  - error: An error if the walk was aborted
+ */
+func (w *walker) walk(fsys fs.FS, logical string) error {
+	return walkDir(fsys, ".", func(rel string, d fs.DirEntry, err error) error {
+		// Report paths as they appear beneath the argument
+		return w.visit(fsys, rel, filepath.Join(logical, filepath.FromSlash(rel)), d, err)
+	})
+}
 
-	processDirectories(dirs)
+/* Handle a single entry from the walk
+ * Parameters:
+ *  - fsys: The tree being walked
+ *  - rel: The entry's path within fsys
+ *  - p: The entry's path as reached from the argument
+ *  - d: Directory entry for the entry
+ *  - err: Any error the walk hit reaching the entry
+ * Returns:
+ *  - error: filepath.SkipDir to prune a directory, or an error to abort the walk
+ */
+func (w *walker) visit(fsys fs.FS, rel, p string, d fs.DirEntry, err error) error {
+	// Errors name the path within fsys, which means little on its own
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		pathErr.Path = p
+	}
+	// Skip what we aren't allowed to read rather than giving up on the whole walk
+	if err != nil && os.IsPermission(err) {
+		if w.quiet {
+			return nil
+		}
+		permissionSkips.Add(1)
+		slog.Debug("skipping unreadable path", "path", p, "err", err)
+		if verboseFlag {
+			fmt.Fprintf(os.Stderr, "Skipping unreadable path %s: %v\n", p, err)
+		}
+		if d != nil && d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Stop as soon as the run is interrupted or -max-runtime is up
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	// Each entry costs a stat, or a readdir for directories
+	if err := throttle(w.ctx); err != nil {
+		return err
+	}
+	// Any entry at all, even an excluded one, means its directory isn't empty
+	if w.empty != nil {
+		delete(w.empty, filepath.Dir(p))
+	}
+	// Skip excluded entries entirely, but never the argument itself
+	if p != w.root {
+		if rule := w.exclusion(p, d.IsDir()); rule != "" {
+			slog.Debug("excluded", "path", p, "rule", rule)
+			if patternStats != nil && !w.quiet {
+				w.countExcluded(rule, d)
+			}
+			if dryRunFlag && !w.quiet {
+				printDryRun("excluded", p, rule)
+			}
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+	}
+	if !d.IsDir() && !w.quiet {
+		if err := checkFileLimit(); err != nil {
+			return err
+		}
+	}
+	// Everything walked takes up an inode, whether or not its bytes are counted
+	w.entries++
+	if d.IsDir() {
+		w.dirs++
+	}
+	if countDirsFlag && w.nodes != nil {
+		w.countEntry(p, d.IsDir())
+	}
+
+	// Only stat the entries whose size or other details are needed.  The directory
+	// listing already says which entries are directories and symlinks.
+	var info fs.FileInfo
+	if d.Type()&fs.ModeSymlink != 0 && (w.followDirs || w.followFiles || strictSymlinksFlag) {
+		target, err := statWithRetry(w.ctx, p, func() (fs.FileInfo, error) { return fs.Stat(fsys, rel) })
+		switch {
+		case err != nil:
+			slog.Debug("counting broken symlink as a link", "path", p, "err", err)
+			if strictSymlinksFlag && errors.Is(err, fs.ErrNotExist) && !w.quiet {
+				danglingLinks.Add(1)
+				if verboseFlag {
+					fmt.Fprintf(os.Stderr, "Dangling symlink %s\n", p)
+				}
+			}
+		case target.IsDir() && w.followDirs:
+			return w.followDir(fsys, rel, p)
+		case !target.IsDir() && w.followFiles:
+			// Count the file the link points to rather than the link itself
+			info = target
+		}
+	}
+	if d.IsDir() {
+		if w.only != "" && p != w.only {
+			return filepath.SkipDir
+		}
+		if !w.descend(p) {
+			slog.Debug("not descending", "path", p, "reason", "-recursive or -depth")
+			return filepath.SkipDir
+		}
+		if p != w.root && noRecurseInto(d.Name()) {
+			slog.Debug("not descending", "path", p, "reason", "-no-recurse-into "+d.Name())
+			return filepath.SkipDir
+		}
+		if !w.sameFileSystem(p, d) {
+			slog.Debug("not descending", "path", p, "reason", "-one-file-system")
+			return filepath.SkipDir
+		}
+		if name := excludedDevice(w.ctx, p, d); name != "" {
+			slog.Debug("not descending", "path", p, "reason", "-exclude-device "+name)
+			return filepath.SkipDir
+		}
+		// Don't walk a directory twice if a link elsewhere leads to it
+		if w.followDirs && !w.markVisited(p) {
+			slog.Debug("not descending", "path", p, "reason", "already walked")
+			return filepath.SkipDir
+		}
+		if w.nodes != nil {
+			w.addNode(p)
+		}
+		if w.dirTimes != nil {
+			info, err := statWithRetry(w.ctx, p, d.Info)
+			if err != nil {
+				return err
+			}
+			if rel, err := filepath.Rel(w.root, p); err == nil {
+				w.dirTimes[filepath.ToSlash(rel)] = info.ModTime()
+			}
+		}
+		if w.entered != nil {
+			if err := w.entered(p); err != nil {
+				return err
+			}
+		}
+		if w.empty != nil {
+			w.empty[p] = true
+		}
+		if w.ignore != nil {
+			return w.ignore.load(w.absPath(p))
+		}
+		return nil
+	}
+
+	if info == nil {
+		if info, err = statWithRetry(w.ctx, p, d.Info); err != nil {
+			return err
+		}
+	}
+	// A file picked by -sample stands for the others in its directory, whether it
+	// is counted or not
+	weight := entryWeight(d)
+	if weight != 1 {
+		w.estimated = true
+	}
+	if rule := fileFilter(p, info); rule != "" {
+		slog.Debug("filtered out", "path", p, "rule", rule)
+		if dryRunFlag && !w.quiet {
+			printDryRun("excluded", p, rule)
+		}
+		return nil
+	}
+	// Only count the first link to a hard-linked file
+	if key, ok := hardLinkKey(info); ok && !countLinksFlag {
+		if w.seen[key] {
+			return nil
+		}
+		w.seen[key] = true
+	}
+	if dryRunFlag && !w.quiet {
+		printDryRun("included", p, "")
+	}
+	size := fileSize(info)
+	w.size += size
+	w.estSize += float64(size) * (weight - 1)
+	if info.Mode().IsRegular() {
+		w.files++
+		w.estFiles += weight - 1
+		if !w.quiet {
+			recordFile(p, info, size)
+			recordLink(w.root, info, size)
+			recordDepth(pathDepth(w.root, filepath.Dir(p)), size)
+			if rel, err := filepath.Rel(w.root, p); err == nil {
+				recordTop(w.root, rel, size)
+			}
+		}
+	}
+	if w.nodes != nil {
+		if n := w.nodes[filepath.Dir(p)]; n != nil {
+			n.Size += size
+			if info.Mode().IsRegular() {
+				n.Files++
+			}
+		}
+	}
+	if progressFlag && !w.quiet {
+		progressFiles.Add(1)
+		progressBytes.Add(size)
+	}
+	return nil
+}
+
+/* Check a file against the -include patterns and the size and modification time
+ * filters.  -exclude has already been checked, so it takes precedence.
+ * Parameters:
+ *  - p: Path of the file
+ *  - info: File info for the file
+ * Returns:
+ *  - bool: false if the file should not be counted
+ */
+func wantFile(p string, info fs.FileInfo) bool {
+	return fileFilter(p, info) == ""
+}
+
+/* Find the filter that rules a file out
+ * Parameters:
+ *  - p: Path of the file
+ *  - info: File info for the file
+ * Returns:
+ *  - string: The flag that rules the file out, or "" if it should be counted
+ */
+func fileFilter(p string, info fs.FileInfo) string {
+	if len(includeFlag) > 0 && !matchesAny(includeFlag, filepath.Base(p)) {
+		return "-include"
+	}
+	if typeExts != nil && !typeExts[strings.ToLower(filepath.Ext(p))] {
+		return "-type"
+	}
+	size := info.Size()
+	if size < int64(minSizeFlag) {
+		return "-min-size"
+	}
+	if maxSizeFlag != 0 && size > int64(maxSizeFlag) {
+		return "-max-size"
+	}
+	mtime := info.ModTime()
+	if !newerThanFlag.t.IsZero() && mtime.Before(newerThanFlag.t) {
+		return "-newer-than"
+	}
+	if !olderThanFlag.t.IsZero() && mtime.After(olderThanFlag.t) {
+		return "-older-than"
+	}
+	// The exact opposites of -newer-than and -older-than, so a file at the bound
+	// is counted by one or the other but never both
+	if !excludeNewerFlag.t.IsZero() && !mtime.Before(excludeNewerFlag.t) {
+		return "-exclude-newer"
+	}
+	if !excludeOlderFlag.t.IsZero() && !mtime.After(excludeOlderFlag.t) {
+		return "-exclude-older"
+	}
+	// Last, since it's by far the slowest
+	if filterCmdFlag != "" && !filterCmdAccepts(p) {
+		return "-filter-cmd"
+	}
+	return ""
+}
+
+/* Parse the -type list of extensions
+ * Parameters:
+ *  - list: Comma-separated extensions, with or without leading dots
+ * Returns:
+ *  - map[string]bool: The extensions in lower case with a leading dot
+ */
+func parseTypes(list string) map[string]bool {
+	exts := make(map[string]bool)
+	for _, ext := range strings.Split(list, ",") {
+		if ext = strings.TrimPrefix(strings.TrimSpace(ext), "."); ext != "" {
+			exts["."+strings.ToLower(ext)] = true
+		}
+	}
+	return exts
+}
+
+/* Set up empty reports for the flags that ask for them, discarding anything
+ * collected so far
+ */
+func resetReports() {
+	if topFlag > 0 {
+		largest = newTopFiles(topFlag)
+	}
+	if topDirsFlag > 0 {
+		largestDirs = newTopFiles(topDirsFlag)
+	}
+	if byExtFlag {
+		byExt = make(breakdown)
+	}
+	if byMimeFlag {
+		byMime = newMimeSniffer()
+	}
+	if byAgeFlag {
+		byAge = newAgeBreakdown(ageBounds)
+	}
+	if byDepthFlag {
+		byDepth = &depthBreakdown{}
+	}
+	if byTopFlag {
+		byTop = make(map[string]breakdown)
+	}
+	if byOwnerFlag {
+		byOwner = make(breakdown)
+	}
+	if byMountFlag {
+		byMount = make(breakdown)
+	}
+	if dupesFlag {
+		duplicates = newDupeFinder()
+	}
+	if estimateCompressionFlag {
+		compressionSample = &compressionSampler{}
+	}
+	if statsFlag {
+		fileStats = newSizeStats(lowMemoryFlag)
+	}
+	if histogramFlag {
+		sizeHistogram = newHistogram(unitBase(), unitLabels())
+	}
+	if sparseFlag {
+		sparseFiles = &sparseFinder{}
+	}
+	if sparseSummaryFlag {
+		sparseTotals = &sparseSummary{}
+	}
+	if extremesFlag {
+		extremeFiles = &extremes{}
+	}
+	emptyPaths = nil
+	listedFiles = nil
+	savedFiles = nil
+	if dedupeAcrossArgsFlag {
+		crossLinks = newLinkTracker()
+	}
+	if patternStatsFlag {
+		patternStats = newPatternCounter()
+	}
+}
+
+/* Start a fresh set of reports and counters, for measuring the same arguments
+ * again with -repeat or -watch
+ */
+func resetRun() {
+	resetReports()
+	progressFiles.Store(0)
+	progressBytes.Store(0)
+	permissionSkips.Store(0)
+	danglingLinks.Store(0)
+	sampleListed.Store(0)
+	sampleTaken.Store(0)
+	deepLinks.Store(0)
+	visitedFiles.Store(0)
+}
+
+/* Count a file the walk has reached against -max-files
+ * Returns:
+ *  - error: An error to stop the walk with once the limit is passed
+ */
+func checkFileLimit() error {
+	if maxFilesFlag > 0 && visitedFiles.Add(1) > maxFilesFlag {
+		return fmt.Errorf("visited more than -max-files %d files; stopping", maxFilesFlag)
+	}
+	return nil
+}
+
+/* Check whether any report that looks at individual files is enabled
+ * Returns:
+ *  - bool: true if a per-file report has been set up
+ */
+func perFileReports() bool {
+	return largest != nil || byExt != nil || byMime != nil || byAge != nil || byDepth != nil || byTop != nil || byOwner != nil || byMount != nil || emptyFlag ||
+		sparseFiles != nil || sparseTotals != nil || duplicates != nil || compressionSample != nil || fileStats != nil || sizeHistogram != nil ||
+		extremeFiles != nil || filesFlag
+}
+
+/* Feed a counted file to the reports that look at individual files
+ * Parameters:
+ *  - p: The file's path as reached from the argument
+ *  - info: File info for the file
+ *  - size: The size the file contributed to the total
+ */
+func recordFile(p string, info fs.FileInfo, size int64) {
+	reportMu.Lock()
+	defer reportMu.Unlock()
+	if largest != nil && (size > 0 || !excludeEmptyFlag) {
+		largest.add(fileEntry{Path: p, Size: size})
+	}
+	if byExt != nil {
+		byExt.add(extensionKey(p), size)
+	}
+	// Files inside archives or on remote hosts can't be opened to sniff them
+	if byMime != nil && !inArchive(info) && !isRemote(p) {
+		byMime.add(p, size)
+	}
+	if byAge != nil {
+		byAge.add(info.ModTime(), size)
+	}
+	if byOwner != nil {
+		byOwner.add(ownerKey(info), size)
+	}
+	if byMount != nil {
+		byMount.add(mountKey(p), size)
+	}
+	if emptyFlag && info.Size() == 0 {
+		emptyPaths = append(emptyPaths, p)
+	}
+	if filesFlag && (size > 0 || !excludeEmptyFlag) {
+		listedFiles = append(listedFiles, fileEntry{Path: p, Size: size})
+	}
+	if sparseFiles != nil {
+		sparseFiles.add(p, info)
+	}
+	if sparseTotals != nil {
+		sparseTotals.add(info)
+	}
+	// Files inside archives or on remote hosts can't be opened to compare their
+	// contents
+	if duplicates != nil && !inArchive(info) && !isRemote(p) {
+		duplicates.add(p, info.Size())
+	}
+	if compressionSample != nil && !inArchive(info) && !isRemote(p) {
+		compressionSample.add(p, size)
+	}
+	if fileStats != nil {
+		fileStats.add(p, size)
+	}
+	if sizeHistogram != nil {
+		sizeHistogram.add(size)
+	}
+	if extremeFiles != nil {
+		extremeFiles.add(p, size, info.ModTime())
+	}
+	// Files inside archives or on remote hosts can't be opened to hash them
+	if hasher != nil && !inArchive(info) && !isRemote(p) {
+		hasher.add(p, info.Size())
+	}
+	if saveFlag != "" {
+		savedFiles = append(savedFiles, snapshotFile{Path: p, Size: size, ModTime: info.ModTime()})
+	}
+	if patternStats != nil && len(includeFlag) > 0 {
+		patternStats.add("-include "+firstMatch(includeFlag, filepath.Base(p)), false, size)
+	}
+}
+
+/* Note a counted file for -dedupe-across-args, if it's hard-linked
+ * Parameters:
+ *  - arg: The argument being measured
+ *  - info: File info for the file
+ *  - size: The size the file contributed to the argument
+ */
+func recordLink(arg string, info fs.FileInfo, size int64) {
+	if crossLinks == nil {
+		return
+	}
+	if key, ok := hardLinkKey(info); ok {
+		crossLinks.add(key, arg, size)
+	}
+}
+
+/* Note a counted file for -by-depth
+ * Parameters:
+ *  - depth: How many directories below the argument the file's directory is
+ *  - size: The size the file contributed to the argument
+ */
+func recordDepth(depth int, size int64) {
+	if byDepth == nil {
+		return
+	}
+	reportMu.Lock()
+	defer reportMu.Unlock()
+	byDepth.add(depth, size)
+}
+
+/* Get the empty files and directories found, for -empty
+ * Returns:
+ *  - []string: Their paths, sorted so that the output doesn't depend on which
+ *    directories finished first
+ */
+func sortedEmptyPaths() []string {
+	sort.Strings(emptyPaths)
+	return emptyPaths
+}
+
+/* Get the files found, for -files
+ * Returns:
+ *  - []fileEntry: The files, in -sort order, or by path without it
+ */
+func sortedFiles() []fileEntry {
+	sort.Slice(listedFiles, func(i, j int) bool {
+		a, b := listedFiles[i], listedFiles[j]
+		return sortsBefore(sortFlag, a.Path, a.Size, b.Path, b.Size)
+	})
+	return listedFiles
+}
+
+/* Get the key a file is grouped under for -by-ext
+ * Parameters:
+ *  - p: The file's path
+ * Returns:
+ *  - string: The file's extension, or "(none)" if it has none
+ */
+func extensionKey(p string) string {
+	if ext := filepath.Ext(p); ext != "" {
+		return ext
+	}
+	return "(none)"
+}
+
+/* Add a directory the walk is entering to the -tree
+ * Parameters:
+ *  - p: The directory's path as reached from the argument
+ */
+func (w *walker) addNode(p string) {
+	n := &dirNode{Path: p}
+	w.nodes[p] = n
+	if p != w.root {
+		if parent := w.nodes[filepath.Dir(p)]; parent != nil {
+			parent.Children = append(parent.Children, n)
+			// It was counted in its parent before it was known to be walked, but
+			// a directory with a node of its own counts itself
+			if countDirsFlag {
+				parent.Dirs--
+				parent.Entries--
+			}
+		}
+	}
+	if countDirsFlag {
+		n.Dirs, n.Entries = 1, 1
+	}
+}
+
+/* Count an entry in the -tree node of the directory it is in, for -count-dirs
+ * Parameters:
+ *  - p: The entry's path as reached from the argument
+ *  - isDir: Whether the entry is a directory
+ */
+func (w *walker) countEntry(p string, isDir bool) {
+	if p == w.root {
+		return
+	}
+	if n := w.nodes[filepath.Dir(p)]; n != nil {
+		n.Entries++
+		if isDir {
+			n.Dirs++
+		}
+	}
+}
+
+/* Find the rule, if any, that keeps an entry out of the walk
+ * Parameters:
+ *  - p: The entry's path as reached from the argument
+ *  - isDir: Whether the entry is a directory
+ * Returns:
+ *  - string: The rule, like "-exclude *.log" or "-gitignore", or "" if the entry
+ *    isn't excluded
+ */
+func (w *walker) exclusion(p string, isDir bool) string {
+	if rule := excludedBy(p); rule != "" {
+		return rule
+	}
+	if re := w.regexpExcluded(p); re != nil {
+		return "-exclude-regexp " + re.String()
+	}
+	if w.gitIgnored(p, isDir) {
+		return "-gitignore"
+	}
+	return ""
+}
+
+/* Count an excluded entry for -pattern-stats
+ * Parameters:
+ *  - rule: The rule that excluded it
+ *  - d: The entry.  A file is stat'd for its size, which the walk would
+ *    otherwise have skipped.
+ */
+func (w *walker) countExcluded(rule string, d fs.DirEntry) {
+	var size int64
+	if !d.IsDir() {
+		if info, err := d.Info(); err == nil {
+			size = fileSize(info)
+		}
+	}
+	patternStats.add(rule, d.IsDir(), size)
+}
+
+/* Check whether a path matches any -exclude-regexp pattern
+ * Parameters:
+ *  - p: The entry's path as reached from the argument
+ * Returns:
+ *  - *regexp.Regexp: The first pattern the path relative to the argument, with
+ *    "/" separators, matches, or nil if none do
+ */
+func (w *walker) regexpExcluded(p string) *regexp.Regexp {
+	if len(excludeRegexps) == 0 {
+		return nil
+	}
+	rel, err := filepath.Rel(w.root, p)
+	if err != nil {
+		return nil
+	}
+	rel = normalizeName(filepath.ToSlash(rel))
+	for _, re := range excludeRegexps {
+		if re.MatchString(rel) {
+			return re
+		}
+	}
+	return nil
+}
+
+/* Check whether a path is ignored by git, with -gitignore
+ * Parameters:
+ *  - p: The entry's path as reached from the argument
+ *  - isDir: Whether the entry is a directory
+ * Returns:
+ *  - bool: true if the entry should be skipped
+ */
+func (w *walker) gitIgnored(p string, isDir bool) bool {
+	return w.ignore != nil && w.ignore.ignored(w.absPath(p), isDir)
+}
+
+/* Get the absolute form of a path below the argument
+ * Parameters:
+ *  - p: The entry's path as reached from the argument
+ * Returns:
+ *  - string: The path made absolute without another call to os.Getwd
+ */
+func (w *walker) absPath(p string) string {
+	return filepath.Join(w.absRoot, strings.TrimPrefix(p, w.root))
+}
+
+/* Check whether the walk should enter a directory
+ * Parameters:
+ *  - p: The directory's path as reached from the argument
+ * Returns:
+ *  - bool: false if -recursive or -depth rule it out
+ */
+func (w *walker) descend(p string) bool {
+	if p == w.root {
+		return true
+	}
+	// If recursion is not enabled, skip subdirectories
+	if !recursiveFlag {
+		return false
+	}
+	// With recursion enabled, don't descend past the requested depth
+	return depthFlag < 0 || pathDepth(w.root, p) <= depthFlag
+}
+
+/* Check whether a directory is on the same filesystem as the argument
+ * Parameters:
+ *  - p: The directory's path as reached from the argument
+ *  - d: Directory entry for the directory
+ * Returns:
+ *  - bool: false if -one-file-system is set and the directory is a mount point
+ *    for a different device.  Always true when the device can't be determined.
+ */
+func (w *walker) sameFileSystem(p string, d fs.DirEntry) bool {
+	if !oneFileSystemFlag {
+		return true
+	}
+	info, err := statWithRetry(w.ctx, p, d.Info)
+	if err != nil {
+		return true
+	}
+	dev, ok := deviceID(info)
+	if !ok {
+		return true
+	}
+	if p == w.root {
+		w.dev = dev
+		return true
+	}
+	return dev == w.dev
+}
+
+/* Check whether a directory is on a device excluded by -exclude-device
+ * Parameters:
+ *  - ctx: Cancelling this stops retrying the stat
+ *  - p: The directory's path as reached from the argument
+ *  - d: Directory entry for the directory
+ * Returns:
+ *  - string: The -exclude-device name that matched, or "" if the directory
+ *    should be walked.  Directories whose device can't be determined are walked.
+ */
+func excludedDevice(ctx context.Context, p string, d fs.DirEntry) string {
+	if len(excludedDevices) == 0 {
+		return ""
+	}
+	info, err := statWithRetry(ctx, p, d.Info)
+	if err != nil {
+		return ""
+	}
+	dev, ok := deviceID(info)
+	if !ok {
+		return ""
+	}
+	return excludedDevices[dev]
+}
+
+/* Walk the directory a symlink points to, as if it were a subdirectory
+ * Parameters:
+ *  - fsys: The tree being walked
+ *  - rel: The symlink's path within fsys
+ *  - p: The symlink's path as reached from the argument
+ * Returns:
+ *  - error: An error if the walk was aborted
+ */
+func (w *walker) followDir(fsys fs.FS, rel, p string) error {
+	if !w.descend(p) {
+		return nil
+	}
+	if w.visited[realPath(p)] {
+		slog.Debug("not following symlink", "path", p, "reason", "already walked")
+		return nil
+	}
+	// Paths that differ can still lead round a loop of links, so cap how many
+	// links deep any one path can go
+	if maxSymlinkDepthFlag > 0 && w.linkDepth >= maxSymlinkDepthFlag {
+		slog.Debug("not following symlink", "path", p, "reason", "-max-symlink-depth")
+		if !w.quiet {
+			deepLinks.Add(1)
+			if verboseFlag {
+				fmt.Fprintf(os.Stderr, "Not following %s: more than %d symlinks deep\n", p, maxSymlinkDepthFlag)
+			}
+		}
+		return nil
+	}
+	sub, err := fs.Sub(fsys, rel)
+	if err != nil {
+		return err
+	}
+	slog.Debug("following symlink", "path", p, "target", realPath(p))
+	w.linkDepth++
+	defer func() { w.linkDepth-- }()
+	return w.walk(sub, p)
+}
+
+/* Resolve a directory to a key that is the same however it is reached
+ * Parameters:
+ *  - p: The directory's path as reached from the argument
+ * Returns:
+ *  - string: Its absolute path with symlinks resolved, or p itself if it isn't on
+ *    disk
+ */
+func realPath(p string) string {
+	real, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return p
+	}
+	if abs, err := filepath.Abs(real); err == nil {
+		return abs
+	}
+	return real
+}
+
+/* Record that a directory has been walked
+ * Parameters:
+ *  - p: The directory's path as reached from the argument
+ * Returns:
+ *  - bool: false if the directory was already walked
+ */
+func (w *walker) markVisited(p string) bool {
+	key := realPath(p)
+	if w.visited[key] {
+		return false
+	}
+	w.visited[key] = true
+	return true
+}
+
+/* Print the size of each directory and calculate the cumulative size
+ * Parameters:
+ *	- ctx: Cancelling this stops the walks, and only completed directories are printed
+ *	- dirs: List of directories to process
+ * Returns:
+ *	- bool: false if any directory couldn't be processed
+ */
+func processDirectories(ctx context.Context, dirs []string) bool {
+	if strictFlag {
+		failed := false
+		for i, other := range findOverlaps(dirs) {
+			if other != "" {
+				fmt.Fprintf(os.Stderr, "%s overlaps %s\n", dirs[i], other)
+				failed = true
+			}
+		}
+		if failed {
+			return false
+		}
+	}
+
+	// With -repeat, only the last run's results are printed
+	var start time.Time
+	var results []dirResult
+	var elapsed []time.Duration
+	for run := 0; run < repeatFlag && ctx.Err() == nil; run++ {
+		if run > 0 {
+			resetRun()
+		}
+		start = time.Now()
+		stopProgress := func() {}
+		if progressFlag {
+			stopProgress = startProgress()
+		}
+		if loadedSnapshot != nil {
+			results = loadedSnapshot.replay()
+		} else {
+			results = measureDirectories(ctx, dirs)
+		}
+		stopProgress()
+		elapsed = append(elapsed, time.Since(start))
+	}
+	hashed := true
+	if hasher != nil {
+		hashed = hasher.finish(manifestFlag)
+	}
+	if dryRunFlag {
+		return true
+	}
+	// A saved run already has its overlaps marked, and its arguments may not
+	// exist here to compare
+	if loadedSnapshot == nil {
+		markOverlaps(results)
+	}
+	saveFailed := false
+	if saveFlag != "" {
+		if err := saveSnapshot(saveFlag, results); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing -save file: %v\n", err)
+			saveFailed = true
+		}
+	}
+
+	if interactiveFlag {
+		if err := browse(results); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return false
+		}
+		ok := true
+		for _, r := range results {
+			if r.Error != "" {
+				printError(r)
+				ok = false
+			}
+		}
+		return ok
+	}
+
+	total, ok := printResults(results)
+	if watchFlag > 0 {
+		rememberScan(results, total)
+	}
+	if sizeBaseline != nil {
+		printBaselineChanges(sizeBaseline, results, total)
+	}
+	if checkFlag {
+		checkTotals(results)
+	}
+	if failOverFlag > 0 && total.Size > int64(failOverFlag) {
+		fmt.Fprintf(os.Stderr, "Total %s exceeds the -fail-over budget of %s by %s\n",
+			formatSize(total.Size), formatSize(int64(failOverFlag)), formatSize(total.Size-int64(failOverFlag)))
+		overBudget = true
+	}
+	if patternStats != nil {
+		patternStats.print()
+	}
+	if n := permissionSkips.Load(); n > 0 {
+		hint := ""
+		if !verboseFlag {
+			hint = " (use -verbose to list them)"
+		}
+		fmt.Fprintf(os.Stderr, "Skipped %d unreadable paths%s\n", n, hint)
+	}
+	if sampleFlag > 0 {
+		printSampleNote()
+	}
+	if n := danglingLinks.Load(); n > 0 {
+		hint := ""
+		if !verboseFlag {
+			hint = " (use -verbose to list them)"
+		}
+		fmt.Fprintf(os.Stderr, "Found %d dangling symlinks%s\n", n, hint)
+		ok = false
+	}
+	if n := deepLinks.Load(); n > 0 {
+		hint := ""
+		if !verboseFlag {
+			hint = " (use -verbose to list them)"
+		}
+		fmt.Fprintf(os.Stderr, "Didn't follow %d symlinks more than -max-symlink-depth %d deep%s\n", n, maxSymlinkDepthFlag, hint)
+	}
+	if repeatFlag > 1 {
+		printRepeatTiming(elapsed)
+	}
+	// Cancelled before the first run started, there's no time to report
+	if timeFlag && len(elapsed) > 0 {
+		printTiming(time.Since(start), total.Size)
+	}
+	if verifyFlag != "" && hasher.changes.any() {
+		ok = false
+	}
+	return ok && !saveFailed && hashed
+}
+
+/* Mark the results for arguments already counted by another argument
+ * Parameters:
+ *	- results: Per-directory results, updated in place
+ */
+func markOverlaps(results []dirResult) {
+	paths := make([]string, len(results))
+	for i, r := range results {
+		paths[i] = r.Path
+	}
+	for i, other := range findOverlaps(paths) {
+		if other != "" {
+			results[i].Overlaps = other
+			fmt.Fprintf(os.Stderr, "Warning: %s overlaps %s; leaving it out of the total\n", paths[i], other)
+		}
+	}
+}
+
+/* Print how long each -repeat run took, on stderr
+ * Parameters:
+ *	- elapsed: Wall-clock time taken to measure every directory, for each run
+ */
+func printRepeatTiming(elapsed []time.Duration) {
+	if len(elapsed) == 0 {
+		fmt.Fprintln(os.Stderr, "No runs completed")
+		return
+	}
+	var sum time.Duration
+	for i, d := range elapsed {
+		fmt.Fprintf(os.Stderr, "Run %d: %s\n", i+1, d.Round(time.Millisecond))
+		sum += d
+	}
+	mean := sum / time.Duration(len(elapsed))
+	fmt.Fprintf(os.Stderr, "Min: %s  Max: %s  Mean: %s\n", slices.Min(elapsed).Round(time.Millisecond),
+		slices.Max(elapsed).Round(time.Millisecond), mean.Round(time.Millisecond))
+}
+
+/* Print how long the run took and how fast it counted bytes, on stderr so it
+ * stays out of machine-readable output
+ * Parameters:
+ *	- elapsed: Wall-clock time taken
+ *	- bytes: Total bytes counted
+ */
+func printTiming(elapsed time.Duration, bytes int64) {
+	rate := ""
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		rate = fmt.Sprintf(" (%s/s)", humanReadableSize(int64(float64(bytes)/seconds), unitBase(), unitLabels()))
+	}
+	fmt.Fprintf(os.Stderr, "Elapsed: %s%s\n", elapsed.Round(time.Millisecond), rate)
+}
+
+/* Print the results of measuring every directory
+ * Parameters:
+ *	- results: Per-directory results
+ * Returns:
+ *	- (dirResult, bool): The cumulative totals, and false if any directory
+ *	  couldn't be processed
+ */
+func printResults(results []dirResult) (dirResult, bool) {
+	ok := true
+	// Each argument is measured by a single worker into its own result, so the
+	// total is only summed here, once they've all finished, and -jobs can't
+	// change it
+	total := dirResult{Path: totalLabelFlag}
+	for _, r := range results {
+		if r.Error != "" {
+			ok = false
+		}
+		if r.Overlaps != "" {
+			continue
+		}
+		total.Size += r.Size
+		total.Files += r.Files
+		total.Estimated = total.Estimated || r.Estimated
+		total.Dirs += r.Dirs
+		total.Entries += r.Entries
+		if r.DereferencedSize != nil {
+			if total.DereferencedSize == nil {
+				total.DereferencedSize = new(int64)
+			}
+			*total.DereferencedSize += *r.DereferencedSize
+		}
+	}
+
+	// Arguments that failed outright may have found some files before they did,
+	// but none of them are in the total
+	if crossLinks != nil {
+		counted := make(map[string]bool)
+		for _, r := range results {
+			if r.Overlaps == "" && (r.Error == "" || r.Partial) {
+				counted[r.Path] = true
+			}
+		}
+		bytes, files := crossLinks.duplicates(counted)
+		total.Size -= bytes
+		total.Files -= files
+		if verboseFlag && files > 0 {
+			fmt.Fprintf(os.Stderr, "Left %d hard links (%s) to files already counted under another argument out of the total\n", files, formatSize(bytes))
+		}
+	}
+
+	if sortFlag != "" {
+		sortResults(results, sortFlag)
+	}
+
+	// With -aggregate only the total is printed, under its label, along with any
+	// errors
+	if aggregateFlag {
+		total.Path = labelFlag
+		failed := results[:0]
+		for _, r := range results {
+			if r.Error != "" {
+				failed = append(failed, r)
+			}
+		}
+		results = failed
+	}
+
+	switch {
+	case jsonFlag:
+		printJSON(results, total)
+	case ndjsonFlag:
+		if !quietFlag {
+			streamTotal(total)
+		}
+	case csvFlag:
+		printCSV(results, total)
+	default:
+		printText(results, total)
+	}
+	return total, ok
+}
+
+/* Call a function for each of n items using a pool of workers, waiting until
+ * every call has returned
+ * Parameters:
+ *	- n: How many items there are
+ *	- jobs: How many calls to make at once, at most
+ *	- fn: Called with each item's index.  Each index is passed to exactly one
+ *	  call, so calls that only write the slots of their own index need no locking.
+ */
+func forEachParallel(n, jobs int, fn func(i int)) {
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// How long a cancelled run waits for its walks to stop before giving up on them
+const abandonAfter = 2 * time.Second
+
+/* Measure every directory using a pool of workers, one per argument being
+ * walked: -jobs of them, or fewer with -parallel-args-limit
+ * Parameters:
+ *	- ctx: Cancelling this stops the walks
+ *	- dirs: List of directories to process
+ * Returns:
+ *	- []dirResult: One result per completed directory, in the same order as dirs
+ */
+func measureDirectories(ctx context.Context, dirs []string) []dirResult {
+	results := make([]dirResult, len(dirs))
+	indexes := make(chan int)
+	// Buffered so that a worker never waits to hand in a result, even once it's
+	// been given up on
+	finished := make(chan int, len(dirs))
+
+	workers := jobsFlag
+	if parallelArgsLimitFlag > 0 {
+		workers = parallelArgsLimitFlag
+	}
+	var wg sync.WaitGroup
+	for n := 0; n < workers && n < len(dirs); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if ctx.Err() != nil {
+					continue
+				}
+				var result dirResult
+				var err error
+				done := false
+				if runCheckpoint != nil {
+					result, done = runCheckpoint.lookup(dirs[i])
+				}
+				switch {
+				case done:
+				case dirCache != nil:
+					result, err = dirCache.measurePath(ctx, dirs[i])
+				default:
+					result, err = measurePath(ctx, dirs[i])
+				}
+				if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+					continue
+				}
+				if runCheckpoint != nil && !done && err == nil {
+					if err := runCheckpoint.record(dirs[i], result); err != nil {
+						fmt.Fprintf(os.Stderr, "Warning: couldn't write checkpoint: %v\n", err)
+					}
+				}
+				switch {
+				case err != nil && result.Path != "":
+					result.Error, result.Partial = err.Error(), true
+				case err != nil:
+					result = dirResult{Path: dirs[i], Error: err.Error(), IsFile: isArchive(dirs[i])}
+				}
+				if ndjsonFlag {
+					streamResult(result)
+				}
+				// Each worker only writes its own slots, and only reads them once
+				// they've been handed in, so no locking is needed
+				results[i] = result
+				finished <- i
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		for i := range dirs {
+			select {
+			case indexes <- i:
+			case <-ctx.Done():
+			}
+		}
+		close(indexes)
+		wg.Wait()
+		close(done)
+	}()
+
+	// A walk stuck in a stat on an unresponsive mount can't be interrupted, so once
+	// the run is cancelled, wait only briefly for the walks to notice before
+	// reporting what has been handed in
+	handedIn := make([]dirResult, len(dirs))
+	cancelled := ctx.Done()
+	var grace <-chan time.Time
+	for waiting := true; waiting; {
+		select {
+		case i := <-finished:
+			handedIn[i] = results[i]
+		case <-done:
+			waiting = false
+		case <-cancelled:
+			cancelled, grace = nil, time.After(abandonAfter)
+		case <-grace:
+			fmt.Fprintln(os.Stderr, "Warning: giving up on walks that didn't stop in time")
+			waiting = false
+		}
+	}
+	for len(finished) > 0 {
+		i := <-finished
+		handedIn[i] = results[i]
+	}
+	results = handedIn
+
+	// Drop the directories that were interrupted or never started
+	completed := results[:0]
+	for _, r := range results {
+		if r.Path != "" {
+			completed = append(completed, r)
+		}
+	}
+	return completed
+}
+
+/* Sort results by size or name
+ * Parameters:
+ *	- results: Per-directory results, sorted in place
+ *	- order: "asc", "desc", "name" or "name-desc"
+ */
+func sortResults(results []dirResult, order string) {
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		return sortsBefore(order, a.Path, a.Size, b.Path, b.Size)
+	})
+}
+
+/* Compare two directories for -sort
+ * Parameters:
+ *	- order: "asc" or "desc" to sort by size, falling back to the name on ties,
+ *	  "name" or "name-desc" to sort by name, or "" to sort by name ascending
+ *	- aPath, aSize: The first directory
+ *	- bPath, bSize: The second directory
+ * Returns:
+ *	- bool: true if the first directory belongs before the second
+ */
+func sortsBefore(order, aPath string, aSize int64, bPath string, bSize int64) bool {
+	switch order {
+	case "asc":
+		if aSize != bSize {
+			return aSize < bSize
+		}
+	case "desc":
+		if aSize != bSize {
+			return aSize > bSize
+		}
+	case "name-desc":
+		return nameLess(bPath, aPath)
+	}
+	return nameLess(aPath, bPath)
+}
+
+/* Compare two paths by name, honouring -ignore-case
+ * Parameters:
+ *	- a, b: The paths
+ * Returns:
+ *	- bool: true if a sorts before b.  Paths that differ only in case are still
+ *	  ordered byte-wise with -ignore-case, so the order is stable.
+ */
+func nameLess(a, b string) bool {
+	if ignoreCaseFlag {
+		if la, lb := strings.ToLower(a), strings.ToLower(b); la != lb {
+			return la < lb
+		}
+	}
+	return a < b
+}
+
+/* Get the unit base for human-readable sizes
+ * Returns:
+ * 	- int64: 1000 with -si, otherwise 1024
+ */
+func unitBase() int64 {
+	if siFlag {
+		return 1000
+	}
+	return 1024
+}
+
+/* Get the unit names for human-readable sizes
+ * Returns:
+ * 	- sizeLabels: kB, MB and so on with -si, KiB, MiB and so on with -iec, or
+ * 	  otherwise KB, MB and so on
+ */
+func unitLabels() sizeLabels {
+	switch {
+	case siFlag:
+		return siLabels
+	case iecFlag:
+		return iecLabels
+	}
+	return binaryLabels
+}
+
+/* Format a size for text output, honouring -human and -color
+ * Parameters:
+ * 	- size: Size in bytes
+ * Returns:
+ * 	- string: The formatted size
+ */
+func formatSize(size int64) string {
+	s := plainSize(size)
+	if colorEnabled {
+		return colorize(size, s)
+	}
+	return s
+}
+
+/* Format a size for text output, honouring -human but not -color
+ * Parameters:
+ * 	- size: Size in bytes
+ * Returns:
+ * 	- string: The formatted size
+ */
+func plainSize(size int64) string {
+	if humanFlag {
+		return humanReadableSize(size, unitBase(), unitLabels())
+	}
+	if commaFlag {
+		return groupDigits(size) + " bytes"
+	}
+	return fmt.Sprintf("%d bytes", size)
+}
+
+/* Format a modification time for text output, honouring -epoch
+ * Parameters:
+ * 	- t: The time
+ * Returns:
+ * 	- string: Unix seconds with -epoch, or the local date and time
+ */
+func formatTime(t time.Time) string {
+	if epochFlag {
+		return strconv.FormatInt(t.Unix(), 10)
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+/* Format one line of text output
+ * Parameters:
+ *	- r: The result to format
+ * Returns:
+ *	- string: The line, without a trailing newline
+ */
+func formatLine(r dirResult) string {
+	line := alignColumns(r.Path, formatSize(r.Size), r.Size)
+	if countFlag {
+		line += fmt.Sprintf(" (%d files)", r.Files)
+	}
+	if countDirsFlag {
+		line += fmt.Sprintf(" (%d dirs, %d entries)", r.Dirs, r.Entries)
+	}
+	if r.DereferencedSize != nil {
+		line += fmt.Sprintf(" (%s following symlinks)", formatSize(*r.DereferencedSize))
+	}
+	if r.Partial {
+		line += " (partial)"
+	}
+	if r.Estimated {
+		line += " (estimated)"
+	}
+	if watchFlag > 0 || watchNotifyFlag {
+		line += formatGrowth(r.Path, r.Size)
+	}
+	return line
+}
+
+/* Format the line for one directory, honouring -format and -percent
+ * Parameters:
+ *	- r: The result to format
+ *	- total: The cumulative size of all directories
+ * Returns:
+ *	- string: The line, without a trailing newline
+ */
+func directoryLine(r dirResult, total int64) string {
+	if lineTemplate != nil {
+		return formatTemplate(lineTemplate, r, total)
+	}
+	return formatBar(r.Size, total) + formatLine(r) + formatShare(r.Size, total)
+}
+
+/* Format a directory's share of the grand total for -percent
+ * Parameters:
+ *	- size: The directory's size
+ *	- total: The cumulative size of all directories
+ * Returns:
+ *	- string: The share, like " (34.5%)", or "" without -percent
+ */
+func formatShare(size, total int64) string {
+	if !percentFlag {
+		return ""
+	}
+	// An empty total means every directory is empty, so none has a share of it
+	share := 0.0
+	if total > 0 {
+		share = float64(size) / float64(total) * 100
+	}
+	return fmt.Sprintf(" (%.1f%%)", share)
+}
+
+/* Print one record of text output
+ * Parameters:
+ *	- line: The record, terminated by a newline or, with -print0, a NUL byte
+ */
+func printRecord(line string) {
+	if print0Flag {
+		fmt.Fprint(output, line, "\x00")
+	} else {
+		fmt.Fprintln(output, line)
+	}
+}
+
+/* Print the heading of a report that follows the totals
+ * Parameters:
+ *	- title: The heading, set off by a blank line except with -print0
+ */
+func printHeading(title string) {
+	if !print0Flag {
+		fmt.Fprintln(output)
+	}
+	printRecord(title)
+}
+
+/* Report on stderr that an argument couldn't be measured, or with -partial, was
+ * only partly measured
+ * Parameters:
+ *	- r: The argument's result, with its error
+ */
+func printError(r dirResult) {
+	kind := "directory"
+	if r.IsFile {
+		kind = "file"
+	}
+	if r.Partial {
+		fmt.Fprintf(os.Stderr, "Warning: %s was only partly measured, stopping at: %s\n", r.Path, r.Error)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Error processing %s %s: %s\n", kind, r.Path, r.Error)
+}
+
+/* Print the line with the cumulative size, honouring -format-total and -quiet
+ * Parameters:
+ *	- total: Cumulative totals of all directories
+ */
+func printTotalLine(total dirResult) {
+	switch {
+	case quietFlag:
+	case totalTemplate != nil:
+		printRecord(formatTemplate(totalTemplate, total, total.Size))
+	default:
+		printRecord(barPadding() + formatLine(total))
+	}
+}
+
+/* Print a line per directory and the total line
+ * Parameters:
+ *	- results: Per-directory results
+ *	- total: Cumulative totals of all directories
+ */
+func printDirectories(results []dirResult, total dirResult) {
+	if alignFlag {
+		setAlignment(results, total)
+	}
+	if totalFirstFlag {
+		printTotalLine(total)
+	}
+	for _, r := range results {
+		if r.Error != "" {
+			printError(r)
+			if !r.Partial {
+				continue
+			}
+		}
+		if summaryFlag {
+			continue
+		}
+		if r.Tree != nil {
+			printTree(r, total.Size)
+		} else if withinThreshold(r.Size) {
+			printRecord(directoryLine(r, total.Size))
+		}
+	}
+
+	if !totalFirstFlag {
+		printTotalLine(total)
+	}
+}
+
+/* Print a line per counted file in place of the directories, for -files
+ * Parameters:
+ *	- results: Per-directory results, whose errors are still reported
+ */
+func printFileList(results []dirResult) {
+	for _, r := range results {
+		if r.Error != "" {
+			printError(r)
+		}
+	}
+	for _, f := range sortedFiles() {
+		printRecord(fmt.Sprintf("%s: %s", f.Path, formatSize(f.Size)))
+	}
+}
+
+/* Print the results as plain or human-readable text
+ * Parameters:
+ *	- results: Per-directory results
+ *	- total: Cumulative totals of all directories
+ */
+func printText(results []dirResult, total dirResult) {
+	if filesFlag {
+		printFileList(results)
+	} else {
+		printDirectories(results, total)
+	}
+
+	if largest != nil {
+		printHeading("Largest files:")
+		for _, f := range largest.sorted() {
+			printRecord(fmt.Sprintf("%s: %s", f.Path, formatSize(f.Size)))
+		}
+	}
+	if largestDirs != nil {
+		printHeading("Largest directories:")
+		for _, d := range largestDirs.sorted() {
+			printRecord(fmt.Sprintf("%s: %s", d.Path, formatSize(d.Size)))
+		}
+	}
+	if byExt != nil {
+		printBreakdown("By extension:", byExt.sorted())
+	}
+	if byMime != nil {
+		printBreakdown("By MIME type:", byMime.sorted())
+	}
+	if byAge != nil {
+		printBreakdown("By age:", byAge.groups)
+	}
+	if byDepth != nil {
+		printBreakdown("By depth:", byDepth.groups)
+	}
+	if byTop != nil {
+		for _, t := range topBreakdowns(results) {
+			printBreakdown("By top-level entry of "+t.Path+":", t.Groups)
+		}
+	}
+	if byOwner != nil {
+		printBreakdown("By owner:", byOwner.sorted())
+	}
+	if byMount != nil {
+		printBreakdown("By mount point:", byMount.sorted())
+	}
+	if emptyFlag {
+		printHeading("Empty files and directories:")
+		for _, p := range sortedEmptyPaths() {
+			printRecord(p)
+		}
+	}
+	if sparseFiles != nil {
+		printSparse(sparseFiles.sorted())
+	}
+	if sparseTotals != nil {
+		printSparseSummary(sparseTotals.summary())
+	}
+	if duplicates != nil {
+		printDuplicates(duplicates.groups())
+	}
+	if compressionSample != nil {
+		printCompressionEstimate(compressionSample.estimate(total.Size))
+	}
+	if fileStats != nil {
+		printStats(fileStats.summary())
+	}
+	if sizeHistogram != nil {
+		printHistogram(sizeHistogram.buckets())
+	}
+	if extremeFiles != nil {
+		printExtremes(extremeFiles)
+	}
+	if verifyFlag != "" {
+		printManifestChanges(&hasher.changes)
+	}
+}
+
+/* Print the file size statistics after the totals
+ * Parameters:
+ *	- st: The statistics to print
+ */
+func printStats(st statsSummary) {
+	printHeading("Statistics:")
+	printRecord(fmt.Sprintf("Files: %d", st.Files))
+	if st.Files == 0 {
+		return
+	}
+	printRecord("Mean: " + formatSize(int64(math.Round(st.Mean))))
+	if st.Approximate {
+		printRecord("Median: about " + formatSize(st.Median))
+	} else {
+		printRecord("Median: " + formatSize(st.Median))
+	}
+	printRecord(fmt.Sprintf("Smallest: %s: %s", st.Smallest.Path, formatSize(st.Smallest.Size)))
+	printRecord(fmt.Sprintf("Largest: %s: %s", st.Largest.Path, formatSize(st.Largest.Size)))
+}
+
+/* Print the groups of duplicate files after the totals
+ * Parameters:
+ *	- groups: The groups, in the order to print them
+ */
+func printDuplicates(groups []dupeGroup) {
+	printHeading("Duplicate files:")
+	var reclaimable int64
+	for _, g := range groups {
+		printRecord(fmt.Sprintf("%d copies of %s (%s reclaimable):", len(g.Paths), formatSize(g.Size), formatSize(g.Reclaimable)))
+		for _, p := range g.Paths {
+			printRecord("  " + p)
+		}
+		reclaimable += g.Reclaimable
+	}
+	printRecord("Reclaimable: " + formatSize(reclaimable))
+}
+
+/* Print a grouped breakdown after the totals
+ * Parameters:
+ *	- title: Heading for the breakdown
+ *	- groups: The groups, in the order to print them
+ */
+func printBreakdown(title string, groups []groupTotal) {
+	printHeading(title)
+	for _, g := range groups {
+		printRecord(fmt.Sprintf("%s: %s (%d files)", g.Key, formatSize(g.Size), g.Files))
+	}
+}
+
+/* Leave the empty directories out of the results, for -exclude-empty in JSON
+ * Parameters:
+ *	- results: Per-directory results, whose trees are pruned in place
+ * Returns:
+ *	- []dirResult: The results with anything in them, and the ones with errors
+ */
+func withoutEmpty(results []dirResult) []dirResult {
+	kept := []dirResult{}
+	for _, r := range results {
+		if r.Error == "" && r.Size == 0 {
+			continue
+		}
+		if r.Tree != nil {
+			r.Tree.dropEmpty()
+		}
+		kept = append(kept, r)
+	}
+	return kept
+}
+
+/* Print the results as a single JSON document
+ * Parameters:
+ *	- results: Per-directory results
+ *	- total: Cumulative totals of all directories
+ */
+func printJSON(results []dirResult, total dirResult) {
+	if excludeEmptyFlag {
+		results = withoutEmpty(results)
+	}
+	report := jsonReport{
+		SchemaVersion: jsonSchemaVersion,
+		Directories:   results,
+	}
+	if !quietFlag {
+		report.Total, report.TotalFiles = &total.Size, &total.Files
+		report.TotalDeref = total.DereferencedSize
+		report.TotalDirs, report.TotalEntries = total.Dirs, total.Entries
+	}
+	if largest != nil {
+		report.LargestFiles = largest.sorted()
+	}
+	if largestDirs != nil {
+		report.LargestDirs = largestDirs.sorted()
+	}
+	if byExt != nil {
+		report.ByExtension = byExt.sorted()
+	}
+	if byMime != nil {
+		report.ByMime = byMime.sorted()
+	}
+	if byAge != nil {
+		report.ByAge = byAge.groups
+	}
+	if byDepth != nil {
+		report.ByDepth = byDepth.groups
+	}
+	if byTop != nil {
+		report.ByTop = topBreakdowns(results)
+	}
+	if byOwner != nil {
+		report.ByOwner = byOwner.sorted()
+	}
+	if byMount != nil {
+		report.ByMount = byMount.sorted()
+	}
+	if emptyFlag {
+		report.Empty = sortedEmptyPaths()
+	}
+	if sparseFiles != nil {
+		report.SparseFiles = sparseFiles.sorted()
+	}
+	if sparseTotals != nil {
+		s := sparseTotals.summary()
+		report.SparseSummary = &s
+	}
+	if duplicates != nil {
+		report.Duplicates = duplicates.groups()
+	}
+	if compressionSample != nil {
+		est := compressionSample.estimate(total.Size)
+		report.Compression = &est
+	}
+	if fileStats != nil {
+		st := fileStats.summary()
+		report.Stats = &st
+	}
+	if sizeHistogram != nil {
+		report.Histogram = sizeHistogram.buckets()
+	}
+	report.Extremes = extremeFiles
+	if verifyFlag != "" {
+		report.Manifest = &hasher.changes
+	}
+	report.encodePaths()
+	enc := json.NewEncoder(output)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		fmt.Fprintf(os.Stderr, "Error encoding JSON: %v\n", err)
+		exitDuringRun(1)
+	}
+}
+
+/* Read newline-separated paths, skipping blank lines
+ * Parameters:
+ *	- r: Where to read the paths from
+ * Returns:
+ *	- ([]string, error): The paths with surrounding whitespace trimmed, or an error
+ *	  if reading failed
+ */
+func readPaths(r io.Reader) ([]string, error) {
+	var paths []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			paths = append(paths, line)
+		}
+	}
+	return paths, scanner.Err()
+}
+
+/* Read NUL-separated paths, as find -print0 writes them
+ * Parameters:
+ *	- r: Where to read the paths from
+ * Returns:
+ *	- ([]string, error): The paths exactly as read, since spaces and newlines
+ *	  can be part of a name, skipping empty ones, or an error if reading failed
+ */
+func readNulPaths(r io.Reader) ([]string, error) {
+	var paths []string
+	scanner := bufio.NewScanner(r)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexByte(data, 0); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		// The last path needn't be terminated
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+	for scanner.Scan() {
+		if p := scanner.Text(); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths, scanner.Err()
+}
+
+/* Read glob patterns from a file, one per line
+ * Parameters:
+ *	- name: Path of the file
+ * Returns:
+ *	- ([]string, error): The patterns with surrounding whitespace trimmed, skipping
+ *	  blank lines and comments starting with "#", or an error if reading failed
+ */
+func readPatterns(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			patterns = append(patterns, line)
+		}
+	}
+	return patterns, scanner.Err()
+}
+
+/* Format one row of CSV output
+ * Parameters:
+ *	- label: The directory's path, already encoded as -path-encoding asks, or
+ *	  the total's label
+ *	- size: The size in bytes
+ * Returns:
+ *	- []string: The path, bytes and human columns
+ */
+func csvRecord(label string, size int64) []string {
+	return []string{label, strconv.FormatInt(size, 10), humanReadableSize(size, unitBase(), unitLabels())}
+}
+
+/* Print the results as CSV, with errors going to stderr
+ * Parameters:
+ *	- results: Per-directory results
+ *	- total: Cumulative totals of all directories
+ */
+func printCSV(results []dirResult, total dirResult) {
+	w := csv.NewWriter(output)
+	w.Write([]string{"path", "bytes", "human"})
+	if totalFirstFlag && !quietFlag {
+		w.Write(csvRecord(total.Path, total.Size))
+	}
+	for _, r := range results {
+		if r.Error != "" {
+			printError(r)
+			if !r.Partial {
+				continue
+			}
+		}
+		if !summaryFlag && withinThreshold(r.Size) {
+			w.Write(csvRecord(encodePath(r.Path), r.Size))
+		}
+	}
+	if !totalFirstFlag && !quietFlag {
+		w.Write(csvRecord(total.Path, total.Size))
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing CSV: %v\n", err)
+		exitDuringRun(1)
+	}
+}
+
+/* Exit after a run was cut short, by Ctrl-C or -max-runtime
+ * Parameters:
+ *	- ctx: The run's context, which says which it was
+ *	- what: What that means for the output, like "totals are partial"
+ */
+func exitCutShort(ctx context.Context, what string) {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		fmt.Fprintf(os.Stderr, "Stopped after -max-runtime %s; %s\n", maxRuntimeFlag, what)
+		os.Exit(exitTimedOut)
+	}
+	fmt.Fprintf(os.Stderr, "Interrupted; %s\n", what)
+	os.Exit(exitInterrupted)
+}
+
+/* Register every command-line flag with its default, on flag.CommandLine
+ */
+func defineFlags() {
+	flag.BoolVar(&humanFlag, "human", false, "Display sizes in human-readable format (e.g., 1K, 234M, 2G)")
+	flag.StringVar(&unitFlag, "unit", "", "Show every size in this unit (K, M, G, T, P or E) so they line up for comparison (implies -human)")
+	flag.IntVar(&precisionFlag, "precision", 1, "Decimal places in human-readable sizes (0 for whole numbers)")
+	flag.BoolVar(&commaFlag, "comma", false, "Display byte counts with thousands separators (e.g., 1,234,567 bytes)")
+	flag.BoolVar(&humanShortFlag, "human-short", false, "Display human-readable sizes tersely, like ls -lh: a single-letter unit and no B (e.g. 1.2G; implies -human, and combines with -si for 1.3k)")
+	flag.BoolVar(&siFlag, "si", false, "With -human, use powers of 1000 (kB, MB, GB) instead of 1024")
+	flag.BoolVar(&iecFlag, "iec", false, "With -human, label powers of 1024 with IEC units (KiB, MiB, GiB) instead of KB, MB, GB")
+	flag.BoolVar(&recursiveFlag, "recursive", false, "Recursively calculate the sizes of directories and subdirectories")
+	flag.BoolVar(&jsonFlag, "json", false, "Emit results as a single JSON object instead of text")
+	flag.BoolVar(&ndjsonFlag, "ndjson", false, "Emit one JSON object per line for each directory as soon as it's measured, then one with the total")
+	flag.BoolVar(&csvFlag, "csv", false, "Emit results as CSV with path, bytes and human columns")
+	flag.StringVar(&pathEncodingFlag, "path-encoding", "utf8", "How -json, -ndjson and -csv write paths: utf8 replaces bytes that aren't valid UTF-8, base64 encodes every path exactly, raw writes them unchanged (-csv only)")
+	flag.IntVar(&sampleFlag, "sample", 0, "Only look at N files picked at random in each directory, and estimate the totals from them, for a quick ballpark of a huge tree (0 = look at every file)")
+	flag.DurationVar(&maxRuntimeFlag, "max-runtime", 0, "Stop after this long (e.g. 30s, 10m), printing what was measured by then and exiting with status 124, as if interrupted (0 = no limit)")
+	flag.BoolVar(&partialFlag, "partial", false, "If a walk fails part way through, report what it counted before the error instead of nothing (still exits with an error)")
+	flag.BoolVar(&excludeEmptyFlag, "exclude-empty", false, "Leave directories and files with a size of zero out of the output; they still count towards -count")
+	flag.BoolVar(&totalFirstFlag, "total-first", false, "Print the cumulative total before the directories rather than after them")
+	flag.BoolVar(&summaryFlag, "summary", false, "Only print the cumulative total, not each directory")
+	flag.BoolVar(&summaryFlag, "s", false, "Shorthand for -summary")
+	flag.Var(&thresholdFlag, "threshold", "Only list directories of at least this size, or at most this size if negative (e.g. 1G, -10M); the total still counts every directory")
+	flag.IntVar(&depthFlag, "depth", -1, "With -recursive, descend at most N levels below each directory (0 = same as non-recursive, negative = unlimited)")
+	flag.Var(&excludeFlag, "exclude", "Skip files and directories whose base name matches this glob pattern (repeatable)")
+	flag.StringVar(&filterCmdFlag, "filter-cmd", "", "Only count files this command accepts: it is run with each file's path as its last argument, and accepts it by exiting with status 0 (split on spaces, without shell quoting)")
+	flag.BoolVar(&filterStreamFlag, "filter-stream", false, "Start -filter-cmd once and send it each file's path on a line of stdin instead, reading y or n back from its stdout")
+	flag.Var(&excludeDeviceFlag, "exclude-device", "Don't descend into directories on this device: a device ID (2049 or 8:1), or a source or mount point from the mount table (/dev/sdb1, server:/export, /mnt/share) (repeatable; needs platform stat support)")
+	flag.Var(&noRecurseFlag, "no-recurse-into", "Never descend into directories with exactly this base name, but still count the directory itself, unlike -exclude which skips it entirely (repeatable)")
+	flag.BoolVar(&patternStatsFlag, "pattern-stats", false, "After the totals, report on stderr how many files and bytes each -exclude and -include pattern matched")
+	flag.Var(&excludeFromFlag, "exclude-from", "Read -exclude patterns from this file, one per line, skipping blank lines and # comments (repeatable)")
+	flag.Var(&excludeRegexpFlag, "exclude-regexp", "Skip files and directories whose path relative to the argument (with / separators) matches this regular expression (repeatable)")
+	flag.Var(&includeFlag, "include", "Only count files whose base name matches this glob pattern (repeatable; -exclude takes precedence)")
+	flag.StringVar(&typeFlag, "type", "", "Only count files with one of these comma-separated extensions, ignoring case (e.g. mp4,mkv,.mov)")
+	flag.BoolVar(&excludeHiddenFlag, "exclude-hidden", false, "Skip files and directories whose name starts with a dot (directories given as arguments are still measured)")
+	flag.BoolVar(&countLinksFlag, "count-links", false, "Count hard-linked files once per link instead of once per inode")
+	flag.BoolVar(&dedupeAcrossArgsFlag, "dedupe-across-args", false, "Count hard-linked files found under several arguments once in the total, though each argument's own line still includes them")
+	flag.BoolVar(&countFlag, "count", false, "Also show the number of regular files counted")
+	flag.BoolVar(&countDirsFlag, "count-dirs", false, "Also show the number of directories and of entries of every kind walked, to gauge inode use")
+	flag.BoolVar(&diskUsageFlag, "disk-usage", false, "Count blocks allocated on disk instead of apparent file size")
+	flag.BoolVar(&followSymlinksFlag, "follow-symlinks", false, "Follow symlinks, walking linked directories and counting the size of linked files")
+	flag.BoolVar(&followDirsFlag, "follow-dirs", false, "Walk directories that symlinks point to, counting links to files as links")
+	flag.BoolVar(&followFilesFlag, "follow-files", false, "Count symlinks to files as the size of the file they point to, without walking linked directories")
+	flag.IntVar(&maxSymlinkDepthFlag, "max-symlink-depth", 40, "With -follow-symlinks or -follow-dirs, don't walk a linked directory reached through more than N symlinks along one path (0 = unlimited)")
+	flag.BoolVar(&strictSymlinksFlag, "strict-symlinks", false, "Report symlinks whose targets don't exist and fail the run if there are any (they are still counted as links; use -verbose to list them)")
+	flag.BoolVar(&dereferenceCountFlag, "dereference-count", false, "Also show each directory's size with symlinks counted as what they point to, as -follow-symlinks would")
+	flag.BoolVar(&followTopLevelFlag, "follow-top-level", false, "Measure what symlinks given as arguments point to, without following symlinks found while walking")
+	flag.BoolVar(&followTopLevelFlag, "L", false, "Shorthand for -follow-top-level")
+	flag.BoolVar(&oneFileSystemFlag, "one-file-system", false, "Don't descend into directories on other filesystems, like du -x (needs platform stat support)")
+	flag.IntVar(&topFlag, "top", 0, "Also list the N largest files across all directories")
+	flag.IntVar(&topDirsFlag, "top-dirs", 0, "Also list the N largest subdirectories across all directories by recursive size (turns on -recursive)")
+	flag.BoolVar(&byExtFlag, "by-ext", false, "Also break the totals down by file extension")
+	flag.BoolVar(&byMimeFlag, "by-mime", false, "Also break the totals down by MIME type, detected from the first 512 bytes of each file (reads every file; use -min-size to skip small ones)")
+	flag.Float64Var(&mergeBelowFlag, "merge-below", 0, "In -by-ext, -by-mime, -by-owner, -by-mount and -by-top, merge the groups with less than this percentage of the total into one (other) group (0 = list every group)")
+	flag.StringVar(&breakdownSortFlag, "breakdown-sort", "size", "Order the groups in -by-ext, -by-mime, -by-owner, -by-mount and -by-top by total size or by file count (size or count), largest first")
+	flag.BoolVar(&byAgeFlag, "by-age", false, "Also break the totals down by how long ago files were modified")
+	flag.BoolVar(&byTopFlag, "by-top", false, "Also break each argument's total down by the entry directly inside it that files are under, with files directly inside it as (root)")
+	flag.BoolVar(&byDepthFlag, "by-depth", false, "Also break the totals down by how many directories below each argument files are, from 0 for the files directly inside it")
+	flag.StringVar(&ageBucketsFlag, "age-buckets", "1d,7d,30d,1y", "With -by-age, the comma-separated ages that separate the groups, youngest first")
+	flag.BoolVar(&byMountFlag, "by-mount", false, "Also break the totals down by the mount point each file is on (Linux only)")
+	flag.BoolVar(&byOwnerFlag, "by-owner", false, "Also break the totals down by the user that owns each file (needs platform stat support)")
+	flag.BoolVar(&progressFlag, "progress", false, "Show a running file count and size on stderr while walking")
+	flag.BoolVar(&gitignoreFlag, "gitignore", false, "Skip files and directories ignored by .gitignore files, inside git repositories")
+	flag.BoolVar(&treeFlag, "tree", false, "With -recursive, print every subdirectory as an indented outline with its subtotal")
+	flag.BoolVar(&childrenFlag, "children", false, "Also list each argument's immediate subdirectories with their recursive sizes, like du --max-depth=1 (implies -recursive; same as -recursive -report-depth 1)")
+	flag.IntVar(&reportDepthFlag, "report-depth", -1, "Print the -tree outline at most N levels below each directory, like du --max-depth, while still counting everything (implies -tree; negative = unlimited)")
+	flag.BoolVar(&percentFlag, "percent", false, "Show each directory's percentage of the cumulative total")
+	flag.BoolVar(&alignFlag, "align", false, "Line up the directory lines, padding the paths and right-justifying the sizes to a common width")
+	flag.BoolVar(&barFlag, "bar", false, "Start each directory's line with a bar showing its share of the cumulative total, scaled to the terminal width")
+	flag.BoolVar(&print0Flag, "print0", false, "End each line of text output with a NUL byte instead of a newline")
+	flag.BoolVar(&filesFlag, "files", false, "List every counted file with its size, one per line, instead of the directory totals (sorted by path, or by -sort)")
+	flag.BoolVar(&emptyFlag, "empty", false, "Also list zero-byte files and directories with no entries, one path per line")
+	flag.BoolVar(&sparseFlag, "sparse", false, "Also list sparse files, with less than half their apparent size allocated on disk (needs platform stat support)")
+	flag.BoolVar(&sparseSummaryFlag, "sparse-summary", false, "Also report the total apparent and allocated size of every file and how much sparseness saves (needs platform stat support)")
+	flag.BoolVar(&estimateCompressionFlag, "estimate-compression", false, "Also estimate the gzipped size of the total by compressing a sample of the files")
+	flag.BoolVar(&dupesFlag, "dupes", false, "Also find files with identical content and report the space they waste (use -min-size to skip small files)")
+	flag.BoolVar(&timeFlag, "time", false, "Print the elapsed time and throughput on stderr when done")
+	flag.StringVar(&cpuprofileFlag, "cpuprofile", "", "Write a CPU profile of the run to this file, for go tool pprof")
+	flag.StringVar(&memprofileFlag, "memprofile", "", "Write a heap profile to this file once the directories are measured, for go tool pprof")
+	flag.BoolVar(&interactiveFlag, "interactive", false, "Browse the subdirectory sizes in the terminal once they're measured, with the arrow keys (implies -recursive and -tree)")
+	flag.DurationVar(&watchFlag, "watch", 0, "Measure the directories again at this interval (like 5s or 1m), showing how much each has grown, until interrupted")
+	flag.BoolVar(&watchNotifyFlag, "watch-fsnotify", false, "Like -watch, but wait for filesystem notifications and only measure again the directories that changed, until interrupted (Linux only, unless built with -tags fsnotify)")
+	flag.BoolVar(&absFlag, "abs", false, "Print every path as an absolute path, however the arguments were given")
+	flag.StringVar(&relFlag, "rel", "", "Print every path relative to this base directory, however the arguments were given")
+	flag.BoolVar(&epochFlag, "epoch", false, "Print modification times in text output as Unix seconds rather than dates")
+	flag.BoolVar(&checkFlag, "check", false, "Warn if an argument that is the top of a filesystem measures much less than the filesystem reports as used (needs platform statfs support)")
+	flag.Float64Var(&checkToleranceFlag, "check-tolerance", 0.9, "With -check, warn when the total is less than this fraction of the used space")
+	flag.BoolVar(&extremesFlag, "extremes", false, "Also report the single oldest and single largest file, without keeping a list of files")
+	flag.BoolVar(&statsFlag, "stats", false, "Also report the mean, median, smallest and largest file size (keeps every file's size in memory, unless -low-memory estimates the median)")
+	flag.BoolVar(&lowMemoryFlag, "low-memory", false, "Keep memory use independent of the number of files: estimate the -stats median, and refuse reports that list every file")
+	flag.BoolVar(&histogramFlag, "histogram", false, "Also show how many files fall into each size range")
+	flag.StringVar(&sortFlag, "sort", "", "Sort directories before printing: by size (asc or desc) or by path (name or name-desc)")
+	flag.BoolVar(&ignoreCaseFlag, "ignore-case", false, "Ignore case when matching -exclude, -include and -no-recurse-into names, and when sorting with -sort name or name-desc")
+	flag.BoolVar(&normalizeUnicodeFlag, "normalize-unicode", false, "Normalize names, patterns and arguments to Unicode NFC before matching and before finding overlapping arguments, so decomposed names match composed patterns (needs -tags norm)")
+	flag.BoolVar(&stdinFlag, "stdin", false, "Also read newline-separated directories from standard input (same as passing -)")
+	flag.BoolVar(&stdin0Flag, "stdin0", false, "Also read NUL-separated directories from standard input, as find -print0 writes them, keeping any spaces and newlines in their names")
+	flag.Var(&blockSizeFlag, "block-size", "Round each file's size up to a multiple of this block size (e.g. 512, 4K), like du --block-size")
+	flag.Var(&failOverFlag, "fail-over", "Exit with status 3 if the total is larger than this size (e.g. 500M, 2G)")
+	flag.Var(&minSizeFlag, "min-size", "Only count files of at least this size (e.g. 500K, 10M)")
+	flag.Var(&maxSizeFlag, "max-size", "Only count files of at most this size (e.g. 4K, 1M; 0 = no limit)")
+	flag.BoolVar(&ignoreErrorsFlag, "ignore-errors", false, "Exit successfully even if some directories couldn't be processed")
+	flag.BoolVar(&reportInaccessibleFlag, "report-inaccessible", false, "Exit with status 4 if unreadable paths were skipped but everything else was measured")
+	flag.Var(&newerThanFlag, "newer-than", "Only count files modified after this time: a duration ago (24h, 30d) or an RFC3339 timestamp")
+	flag.Var(&olderThanFlag, "older-than", "Only count files modified before this time: a duration ago (24h, 30d) or an RFC3339 timestamp")
+	flag.Var(&excludeNewerFlag, "exclude-newer", "Skip files modified at or after this time, counting everything -newer-than would leave out: a duration ago (24h, 30d) or an RFC3339 timestamp")
+	flag.Var(&excludeOlderFlag, "exclude-older", "Skip files modified at or before this time, counting everything -older-than would leave out: a duration ago (24h, 30d) or an RFC3339 timestamp")
+	flag.IntVar(&readdirBufferFlag, "readdir-buffer", 0, "Read directories this many entries at a time rather than all at once, to tune very large directories (0 = all at once)")
+	flag.IntVar(&jobsFlag, "jobs", runtime.NumCPU(), "Number of directories to measure, and files to hash for -dupes, sniff for -by-mime or compress for -estimate-compression, concurrently")
+	flag.IntVar(&parallelArgsLimitFlag, "parallel-args-limit", 0, "Walk at most N arguments at once, whatever -jobs is, while hashing, sniffing and compressing files still use -jobs workers (0 = as many as -jobs)")
+	flag.StringVar(&colorFlag, "color", "never", "Colorize sizes by magnitude in text output: auto (only on a terminal), always or never")
+	flag.Var(&colorMediumFlag, "color-medium", "With -color, sizes from this one up are shown in yellow")
+	flag.Var(&colorLargeFlag, "color-large", "With -color, sizes from this one up are shown in red")
+	flag.StringVar(&cacheFlag, "cache", "", "Reuse sizes stored in this JSON file for directories whose modification time hasn't changed, and update it")
+	flag.StringVar(&formatFlag, "format", "", "Format each directory's line with this Go text/template, using {{.Path}}, {{.Bytes}}, {{.Human}}, {{.Files}} and {{.Percent}}")
+	flag.StringVar(&formatTotalFlag, "format-total", "", "Format the total's line with this template (default: the -format template)")
+	flag.StringVar(&logLevelFlag, "log-level", "warn", "Write structured logs of what the walk does to stderr at this level and above (debug, info, warn or error)")
+	flag.BoolVar(&verboseFlag, "verbose", false, "List each path skipped because it couldn't be read, and each -retry, on stderr")
+	flag.Int64Var(&maxFilesFlag, "max-files", 0, "Stop with an error once N files have been visited across all the arguments, as a guard against runaway trees (0 = unlimited; use -partial to still see the counts)")
+	flag.IntVar(&rateFlag, "rate", 0, "Walk at most N files and directories per second, to go easy on busy fileservers (0 = unlimited)")
+	flag.IntVar(&retryFlag, "retry", 0, "Retry stat calls that fail with EINTR, ESTALE or EIO up to N times, backing off exponentially from 50ms to at most 5s")
+	flag.BoolVar(&diffFlag, "diff", false, "Compare two directories, printing the change in size of every subdirectory that differs (implies -recursive)")
+	flag.BoolVar(&aggregateFlag, "aggregate", false, "Print a single line with the combined size of every argument instead of one per argument")
+	flag.StringVar(&labelFlag, "label", "", "With -aggregate, the name to print the combined size under (default: the -total-label)")
+	flag.StringVar(&totalLabelFlag, "total-label", "Total", "The name to print the cumulative total under, in place of Total")
+	flag.BoolVar(&quietFlag, "quiet", false, "Leave out the cumulative total, in text, CSV and JSON output alike")
+	flag.BoolVar(&dryRunFlag, "dry-run", false, "Instead of printing sizes, list every file as included or excluded, and every excluded directory, with the rule that excluded it")
+	flag.StringVar(&saveFlag, "save", "", "Also save every directory's result and every counted file to this JSON file, for -load to report on again")
+	flag.StringVar(&loadFlag, "load", "", "Report on a run saved with -save instead of measuring anything, with this run's output, sorting and report flags")
+	flag.BoolVar(&mergeFlag, "merge", false, "Treat the arguments as files saved with -save, each optionally given as LABEL=FILE, and report on them combined as one run, like -load")
+	flag.BoolVar(&estimateFlag, "estimate", false, "Only count the entries measuring the arguments would reach, from the directory listings alone, and print how long measuring them might take")
+	flag.IntVar(&estimateRateFlag, "estimate-rate", 10000, "With -estimate, the entries per second a real run is assumed to measure")
+	flag.StringVar(&manifestFlag, "manifest", "", "Also write the SHA-256, size and path of every counted file to this file, as \"hash  size  path\" lines")
+	flag.StringVar(&verifyFlag, "verify", "", "Also hash every counted file and compare it with this -manifest file, listing the files changed, added and removed since, and fail if there are any")
+	flag.BoolVar(&validateFlag, "validate", false, "Check that every argument exists and can be read before measuring any of them, reporting all that can't, and measure nothing if one can't")
+	flag.BoolVar(&skipInvalidFlag, "skip-invalid", false, "With -validate, measure the arguments that passed instead of stopping; the exit status still shows the failures")
+	flag.BoolVar(&strictFlag, "strict", false, "Fail without measuring anything if an argument repeats another or, with -recursive, is inside another")
+	flag.IntVar(&repeatFlag, "repeat", 1, "Measure the directories N times, printing each run's time on stderr and only the last run's results, for profiling")
+	flag.StringVar(&outputFlag, "output", "", "Write results to this file instead of stdout, creating or truncating it")
+	flag.StringVar(&baselineFlag, "baseline", "", "Compare the sizes with a previous -json result saved in this file, showing what each directory gained or lost")
+	flag.Float64Var(&baselineGrowthFlag, "baseline-growth", 10, "With -baseline, flag directories that grew by more than this percentage")
+	flag.StringVar(&checkpointFlag, "checkpoint", "", "Record each argument in this file as soon as it's measured, skip the ones already recorded there, and remove the file once the run succeeds, so an interrupted run can be resumed")
+	flag.StringVar(&configFlag, "config", "", "Read default flag values from this JSON file instead of ~/.hellofordrc; flags on the command line take precedence")
+	flag.BoolVar(&versionFlag, "version", false, "Print version information and exit")
+	flag.BoolVar(&printSchemaFlag, "print-schema", false, "Print a JSON Schema describing the -json and -ndjson output and exit")
+}
+
+func main() {
+	// Parse command-line flags
+	defineFlags()
+	flag.Parse()
+
+	if versionFlag {
+		printVersion()
+		return
+	}
+	if name, required := configPath(configFlag); name != "" {
+		if err := loadConfig(name, required); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading config file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(logLevelFlag)); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -log-level value %q: must be debug, info, warn or error\n", logLevelFlag)
+		os.Exit(exitUsage)
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+
+	if printSchemaFlag {
+		printSchema()
+		return
+	}
+
+	switch sortFlag {
+	case "", "asc", "desc", "name", "name-desc":
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid -sort value %q: must be asc, desc, name or name-desc\n", sortFlag)
+		os.Exit(exitUsage)
+	}
+	if mergeBelowFlag < 0 || mergeBelowFlag > 100 {
+		fmt.Fprintf(os.Stderr, "Invalid -merge-below value %g: must be a percentage from 0 to 100\n", mergeBelowFlag)
+		os.Exit(exitUsage)
+	}
+	if breakdownSortFlag != "size" && breakdownSortFlag != "count" {
+		fmt.Fprintf(os.Stderr, "Invalid -breakdown-sort value %q: must be size or count\n", breakdownSortFlag)
+		os.Exit(exitUsage)
+	}
+	if !slices.Contains([]string{"utf8", "base64", "raw"}, pathEncodingFlag) {
+		fmt.Fprintf(os.Stderr, "Invalid -path-encoding value %q: must be utf8, base64 or raw\n", pathEncodingFlag)
+		os.Exit(exitUsage)
+	}
+	if pathEncodingFlag == "raw" && (jsonFlag || ndjsonFlag) {
+		// encoding/json would quietly replace the bytes anyway
+		fmt.Fprintln(os.Stderr, "-path-encoding raw only applies to -csv, since JSON strings can't hold bytes that aren't valid UTF-8; use utf8 or base64")
+		os.Exit(exitUsage)
+	}
+	if totalLabelFlag == "" {
+		fmt.Fprintln(os.Stderr, "-total-label must not be empty")
+		os.Exit(exitUsage)
+	}
+	if labelFlag == "" {
+		labelFlag = totalLabelFlag
+	}
+	if jsonFlag && csvFlag {
+		fmt.Fprintln(os.Stderr, "-json and -csv are mutually exclusive")
+		os.Exit(exitUsage)
+	}
+	if ndjsonFlag && (jsonFlag || csvFlag || formatFlag != "" || formatTotalFlag != "" || print0Flag || sortFlag != "" || totalFirstFlag || diffFlag || repeatFlag > 1) {
+		fmt.Fprintln(os.Stderr, "-ndjson writes directories as they finish and can't be combined with -json, -csv, -format, -print0, -sort, -total-first, -diff or -repeat")
+		os.Exit(exitUsage)
+	}
+	if formatFlag != "" || formatTotalFlag != "" {
+		if jsonFlag || csvFlag {
+			fmt.Fprintln(os.Stderr, "-format only applies to text output and can't be combined with -json or -csv")
+			os.Exit(exitUsage)
+		}
+		var err error
+		if formatFlag != "" {
+			if lineTemplate, err = parseFormat("format", formatFlag); err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid -format template: %v\n", err)
+				os.Exit(exitUsage)
+			}
+		}
+		totalTemplate = lineTemplate
+		if formatTotalFlag != "" {
+			if totalTemplate, err = parseFormat("format-total", formatTotalFlag); err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid -format-total template: %v\n", err)
+				os.Exit(exitUsage)
+			}
+		}
+	}
+	// -follow-symlinks is both halves at once
+	if followSymlinksFlag {
+		followDirsFlag, followFilesFlag = true, true
+	}
+	if dereferenceCountFlag && (followDirsFlag || followFilesFlag) {
+		fmt.Fprintln(os.Stderr, "-dereference-count already shows sizes with and without following symlinks and can't be combined with -follow-symlinks, -follow-dirs or -follow-files")
+		os.Exit(exitUsage)
+	}
+	if excludeEmptyFlag && emptyFlag {
+		fmt.Fprintln(os.Stderr, "-exclude-empty hides what -empty lists and can't be combined with it")
+		os.Exit(exitUsage)
+	}
+	if dryRunFlag && (jsonFlag || ndjsonFlag || csvFlag || diffFlag) {
+		fmt.Fprintln(os.Stderr, "-dry-run prints its own text listing and can't be combined with -json, -ndjson, -csv or -diff")
+		os.Exit(exitUsage)
+	}
+	if aggregateFlag && quietFlag {
+		fmt.Fprintln(os.Stderr, "-aggregate only prints the total and can't be combined with -quiet")
+		os.Exit(exitUsage)
+	}
+	if quietFlag && summaryFlag {
+		fmt.Fprintln(os.Stderr, "-quiet and -summary are mutually exclusive")
+		os.Exit(exitUsage)
+	}
+	if precisionFlag < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -precision value %d: must not be negative\n", precisionFlag)
+		os.Exit(exitUsage)
+	}
+	if unitFlag != "" {
+		if name := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(unitFlag), "B"), "I"); len(name) == 1 {
+			unitExp = strings.IndexByte("KMGTPE", name[0])
+		}
+		if unitExp < 0 {
+			fmt.Fprintf(os.Stderr, "Invalid -unit value %q: must be K, M, G, T, P or E\n", unitFlag)
+			os.Exit(exitUsage)
+		}
+		humanFlag = true
+	}
+	if humanShortFlag {
+		humanFlag = true
+	}
+	if siFlag && iecFlag {
+		fmt.Fprintln(os.Stderr, "-si and -iec are mutually exclusive")
+		os.Exit(exitUsage)
+	}
+	if commaFlag && humanFlag {
+		fmt.Fprintln(os.Stderr, "-comma and -human are mutually exclusive")
+		os.Exit(exitUsage)
+	}
+	if print0Flag && (jsonFlag || csvFlag) {
+		fmt.Fprintln(os.Stderr, "-print0 only applies to text output and can't be combined with -json or -csv")
+		os.Exit(exitUsage)
+	}
+	if colorFlag != "auto" && colorFlag != "always" && colorFlag != "never" {
+		fmt.Fprintf(os.Stderr, "Invalid -color value %q: must be auto, always or never\n", colorFlag)
+		os.Exit(exitUsage)
+	}
+	if barFlag && (jsonFlag || ndjsonFlag || csvFlag || lineTemplate != nil) {
+		fmt.Fprintln(os.Stderr, "-bar only applies to text output and can't be combined with -json, -ndjson, -csv or -format")
+		os.Exit(exitUsage)
+	}
+	if alignFlag && (jsonFlag || ndjsonFlag || csvFlag || lineTemplate != nil || filesFlag) {
+		fmt.Fprintln(os.Stderr, "-align only applies to the directory lines of text output and can't be combined with -json, -ndjson, -csv, -format or -files")
+		os.Exit(exitUsage)
+	}
+	if repeatFlag < 1 {
+		fmt.Fprintf(os.Stderr, "Invalid -repeat value %d: must be at least 1\n", repeatFlag)
+		os.Exit(exitUsage)
+	}
+	if rateFlag < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -rate value %d: must not be negative\n", rateFlag)
+		os.Exit(exitUsage)
+	}
+	startRateLimit(rateFlag)
+	if maxFilesFlag < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -max-files value %d: must not be negative\n", maxFilesFlag)
+		os.Exit(exitUsage)
+	}
+	if maxSymlinkDepthFlag < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -max-symlink-depth value %d: must not be negative\n", maxSymlinkDepthFlag)
+		os.Exit(exitUsage)
+	}
+	if retryFlag < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -retry value %d: must not be negative\n", retryFlag)
+		os.Exit(exitUsage)
+	}
+	if jobsFlag < 1 {
+		fmt.Fprintf(os.Stderr, "Invalid -jobs value %d: must be at least 1\n", jobsFlag)
+		os.Exit(exitUsage)
+	}
+	if maxRuntimeFlag < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -max-runtime value %s: must not be negative\n", maxRuntimeFlag)
+		os.Exit(exitUsage)
+	}
+	if sampleFlag < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -sample value %d: must not be negative\n", sampleFlag)
+		os.Exit(exitUsage)
+	}
+	if parallelArgsLimitFlag < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -parallel-args-limit value %d: must not be negative\n", parallelArgsLimitFlag)
+		os.Exit(exitUsage)
+	}
+	if readdirBufferFlag < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -readdir-buffer value %d: must not be negative\n", readdirBufferFlag)
+		os.Exit(exitUsage)
+	}
+	// Zero means no rounding, but only as the default
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "block-size" && blockSizeFlag <= 0 {
+			fmt.Fprintln(os.Stderr, "-block-size must be at least 1 byte")
+			os.Exit(exitUsage)
+		}
+	})
+	if maxSizeFlag != 0 && maxSizeFlag < minSizeFlag {
+		fmt.Fprintf(os.Stderr, "Invalid -max-size %d: smaller than -min-size %d\n", maxSizeFlag, minSizeFlag)
+		os.Exit(exitUsage)
+	}
+	if !newerThanFlag.t.IsZero() && !olderThanFlag.t.IsZero() && olderThanFlag.t.Before(newerThanFlag.t) {
+		fmt.Fprintf(os.Stderr, "Invalid time window: -older-than %s is before -newer-than %s\n", &olderThanFlag, &newerThanFlag)
+		os.Exit(exitUsage)
+	}
+	if diskUsageFlag && !sysStatSupported {
+		fmt.Fprintln(os.Stderr, "Warning: -disk-usage is not supported on this platform; using apparent sizes")
+		diskUsageFlag = false
+	}
+	if filterCmdFlag != "" {
+		if _, err := filterArgs(filterCmdFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -filter-cmd value: %v\n", err)
+			os.Exit(exitUsage)
+		}
+	} else if filterStreamFlag {
+		fmt.Fprintln(os.Stderr, "-filter-stream needs -filter-cmd")
+		os.Exit(exitUsage)
+	}
+	if len(excludeDeviceFlag) > 0 {
+		if !sysStatSupported {
+			fmt.Fprintln(os.Stderr, "Warning: -exclude-device is not supported on this platform; not excluding any devices")
+		} else {
+			var err error
+			if excludedDevices, err = resolveExcludedDevices(excludeDeviceFlag); err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid -exclude-device value: %v\n", err)
+				os.Exit(exitUsage)
+			}
+		}
+	}
+	if oneFileSystemFlag && !sysStatSupported {
+		fmt.Fprintln(os.Stderr, "Warning: -one-file-system is not supported on this platform; crossing filesystems")
+		oneFileSystemFlag = false
+	}
+	if sparseFlag && !sysStatSupported {
+		fmt.Fprintln(os.Stderr, "Warning: -sparse is not supported on this platform; not looking for sparse files")
+		sparseFlag = false
+	}
+	if sparseSummaryFlag && !sysStatSupported {
+		fmt.Fprintln(os.Stderr, "Warning: -sparse-summary is not supported on this platform; not totalling allocated sizes")
+		sparseSummaryFlag = false
+	}
+	if normalizeUnicodeFlag && !unicodeNormSupported {
+		fmt.Fprintln(os.Stderr, "-normalize-unicode needs golang.org/x/text and a build with -tags norm")
+		os.Exit(exitUsage)
+	}
+	if byMountFlag && !mountTableSupported {
+		fmt.Fprintln(os.Stderr, "Warning: -by-mount is not supported on this platform; not grouping by mount point")
+		byMountFlag = false
+	}
+	if byMountFlag {
+		if err := setupMountPoints(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading mount points: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if checkToleranceFlag <= 0 || checkToleranceFlag > 1 {
+		fmt.Fprintln(os.Stderr, "-check-tolerance must be more than 0 and at most 1")
+		os.Exit(exitUsage)
+	}
+	if checkFlag && (!statfsSupported || !sysStatSupported) {
+		fmt.Fprintln(os.Stderr, "Warning: -check is not supported on this platform; not comparing totals with filesystem usage")
+		checkFlag = false
+	}
+	if byOwnerFlag && !sysStatSupported {
+		fmt.Fprintln(os.Stderr, "Warning: -by-owner is not supported on this platform; not grouping by owner")
+		byOwnerFlag = false
+	}
+	if diffFlag {
+		if jsonFlag || csvFlag || totalTemplate != nil {
+			fmt.Fprintln(os.Stderr, "-diff only prints text and can't be combined with -json, -csv or -format")
+			os.Exit(exitUsage)
+		}
+		// The comparison needs every subdirectory's size
+		recursiveFlag = true
+		treeFlag = true
+	}
+	if baselineFlag != "" && (jsonFlag || ndjsonFlag || csvFlag || diffFlag || interactiveFlag || dryRunFlag) {
+		fmt.Fprintln(os.Stderr, "-baseline prints its comparison as text and can't be combined with -json, -ndjson, -csv, -diff, -interactive or -dry-run")
+		os.Exit(exitUsage)
+	}
+	if failOverFlag < 0 {
+		fmt.Fprintln(os.Stderr, "-fail-over must not be negative")
+		os.Exit(exitUsage)
+	}
+	if failOverFlag > 0 && (diffFlag || watchFlag > 0 || interactiveFlag || dryRunFlag) {
+		fmt.Fprintln(os.Stderr, "-fail-over checks a single total and can't be combined with -diff, -watch, -interactive or -dry-run")
+		os.Exit(exitUsage)
+	}
+	if watchFlag < 0 {
+		fmt.Fprintln(os.Stderr, "-watch must be a positive interval")
+		os.Exit(exitUsage)
+	}
+	if watchFlag > 0 && (jsonFlag || ndjsonFlag || csvFlag || diffFlag || dryRunFlag || interactiveFlag || repeatFlag > 1) {
+		fmt.Fprintln(os.Stderr, "-watch prints a text report for each scan and can't be combined with -json, -ndjson, -csv, -diff, -dry-run, -interactive or -repeat")
+		os.Exit(exitUsage)
+	}
+	if interactiveFlag {
+		if !rawModeSupported {
+			fmt.Fprintln(os.Stderr, "-interactive is not supported on this platform")
+			os.Exit(exitUsage)
+		}
+		if jsonFlag || ndjsonFlag || csvFlag || totalTemplate != nil || lineTemplate != nil || diffFlag || dryRunFlag || outputFlag != "" || repeatFlag > 1 {
+			fmt.Fprintln(os.Stderr, "-interactive draws its own screen and can't be combined with -json, -ndjson, -csv, -format, -diff, -dry-run, -output or -repeat")
+			os.Exit(exitUsage)
+		}
+		if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+			fmt.Fprintln(os.Stderr, "-interactive needs a terminal on stdin and stdout")
+			os.Exit(1)
+		}
+		recursiveFlag = true
+		treeFlag = true
+		// Show the biggest directories first unless asked otherwise
+		if sortFlag == "" {
+			sortFlag = "desc"
+		}
+	}
+	if topDirsFlag < 0 {
+		fmt.Fprintln(os.Stderr, "-top-dirs must not be negative")
+		os.Exit(exitUsage)
+	}
+	if topDirsFlag > 0 {
+		// Subdirectories only have sizes of their own if the walk goes into them
+		recursiveFlag = true
+	}
+	if childrenFlag {
+		if reportDepthFlag >= 0 {
+			fmt.Fprintln(os.Stderr, "-children already sets the report depth to 1 and can't be combined with -report-depth")
+			os.Exit(exitUsage)
+		}
+		recursiveFlag = true
+		reportDepthFlag = 1
+	}
+	if reportDepthFlag >= 0 {
+		treeFlag = true
+	}
+	if byAgeFlag {
+		var err error
+		if ageBounds, err = parseAgeBuckets(ageBucketsFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -age-buckets value: %v\n", err)
+			os.Exit(exitUsage)
+		}
+	}
+	if filesFlag && (jsonFlag || ndjsonFlag || csvFlag || lineTemplate != nil || totalTemplate != nil || diffFlag || interactiveFlag || watchFlag > 0) {
+		fmt.Fprintln(os.Stderr, "-files prints a text list and can't be combined with -json, -ndjson, -csv, -format, -diff, -interactive or -watch")
+		os.Exit(exitUsage)
+	}
+	if lowMemoryFlag && (dupesFlag || byMimeFlag || emptyFlag || sparseFlag || treeFlag || topDirsFlag > 0 || filesFlag) {
+		fmt.Fprintln(os.Stderr, "-low-memory can't be combined with -dupes, -by-mime, -empty, -sparse, -files, -top-dirs or -tree (or the flags that imply it), which keep a list of every file or directory")
+		os.Exit(exitUsage)
+	}
+	if patternStatsFlag && checkpointFlag != "" {
+		fmt.Fprintln(os.Stderr, "-pattern-stats needs every argument walked and can't be combined with -checkpoint")
+		os.Exit(exitUsage)
+	}
+	if dedupeAcrossArgsFlag && (countLinksFlag || diffFlag || checkpointFlag != "") {
+		fmt.Fprintln(os.Stderr, "-dedupe-across-args needs every hard link seen in this run and can't be combined with -count-links, -diff or -checkpoint")
+		os.Exit(exitUsage)
+	}
+	resetReports()
+	if checkpointFlag != "" && (perFileReports() || diffFlag || watchFlag > 0 || repeatFlag > 1) {
+		fmt.Fprintln(os.Stderr, "-checkpoint only keeps each argument's totals, so it can't be combined with -diff, -watch, -repeat or the reports that look at individual files")
+		os.Exit(exitUsage)
+	}
+	if (manifestFlag != "" || verifyFlag != "") && (sampleFlag > 0 || checkpointFlag != "" || loadFlag != "" || mergeFlag || diffFlag || watchFlag > 0 || repeatFlag > 1 || dryRunFlag || ndjsonFlag) {
+		fmt.Fprintln(os.Stderr, "-manifest and -verify hash every file of a single run, so they can't be combined with -sample, -checkpoint, -load, -merge, -diff, -watch, -repeat, -dry-run or -ndjson")
+		os.Exit(exitUsage)
+	}
+	if sampleFlag > 0 && (perFileReports() || treeFlag || topDirsFlag > 0 || countDirsFlag || dereferenceCountFlag || diffFlag || dryRunFlag || saveFlag != "" || dedupeAcrossArgsFlag || patternStatsFlag) {
+		fmt.Fprintln(os.Stderr, "-sample only estimates each argument's total, so it can't be combined with -tree, -children, -top-dirs, -count-dirs, -dereference-count, -diff, -dry-run, -save, "+
+			"-dedupe-across-args, -pattern-stats or the reports that look at individual files")
+		os.Exit(exitUsage)
+	}
+	if watchNotifyFlag {
+		if !notifySupported {
+			fmt.Fprintln(os.Stderr, "-watch-fsnotify is only supported on Linux unless built with -tags fsnotify; use -watch")
+			os.Exit(exitUsage)
+		}
+		if watchFlag > 0 || jsonFlag || ndjsonFlag || csvFlag || diffFlag || dryRunFlag || interactiveFlag || repeatFlag > 1 || filesFlag ||
+			perFileReports() || treeFlag || topDirsFlag > 0 || countDirsFlag || dereferenceCountFlag || sampleFlag > 0 || followDirsFlag ||
+			saveFlag != "" || loadFlag != "" || mergeFlag || checkpointFlag != "" || cacheFlag != "" || manifestFlag != "" || verifyFlag != "" ||
+			dedupeAcrossArgsFlag || patternStatsFlag || strictSymlinksFlag {
+			fmt.Fprintln(os.Stderr, "-watch-fsnotify only keeps each directory's own total and prints a text report, so it can't be combined with -watch, -json, -ndjson, -csv, "+
+				"-diff, -dry-run, -interactive, -repeat, -files, -tree, -children, -top-dirs, -count-dirs, -dereference-count, -sample, -follow-symlinks, -follow-dirs, "+
+				"-save, -load, -merge, -checkpoint, -cache, -manifest, -verify, -dedupe-across-args, -pattern-stats, -strict-symlinks or the reports that look at individual files")
+			os.Exit(exitUsage)
+		}
+	}
+	if ndjsonFlag && (perFileReports() || topDirsFlag > 0) {
+		fmt.Fprintln(os.Stderr, "-ndjson only reports directories; use -json for -top, -top-dirs, -by-ext, -by-mime, -by-age, -by-depth, -by-top, -by-owner, -by-mount, -empty, -sparse, -sparse-summary, -dupes, -estimate-compression, -stats, -histogram and -extremes")
+		os.Exit(exitUsage)
+	}
+	for _, name := range excludeFromFlag {
+		patterns, err := readPatterns(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading -exclude-from file: %v\n", err)
+			os.Exit(1)
+		}
+		excludeFlag = append(excludeFlag, patterns...)
+	}
+	for _, pattern := range excludeFlag {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -exclude pattern %q: %v\n", pattern, err)
+			os.Exit(exitUsage)
+		}
+	}
+	for _, pattern := range excludeRegexpFlag {
+		re, err := regexp.Compile(normalizeName(pattern))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -exclude-regexp pattern %q: %v\n", pattern, err)
+			os.Exit(exitUsage)
+		}
+		excludeRegexps = append(excludeRegexps, re)
+	}
+	if typeFlag != "" {
+		if typeExts = parseTypes(typeFlag); len(typeExts) == 0 {
+			fmt.Fprintf(os.Stderr, "Invalid -type value %q: no extensions given\n", typeFlag)
+			os.Exit(exitUsage)
+		}
+	}
+	for _, pattern := range includeFlag {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -include pattern %q: %v\n", pattern, err)
+			os.Exit(exitUsage)
+		}
+	}
+	if patternStatsFlag && len(excludeFlag) == 0 && len(includeFlag) == 0 {
+		fmt.Fprintln(os.Stderr, "-pattern-stats needs at least one -exclude, -exclude-from or -include pattern")
+		os.Exit(exitUsage)
+	}
+
+	// Remaining command-line arguments are the directories, and a lone "-" means
+	// read them from stdin
+	dirs := flag.Args()
+	if len(dirs) == 1 && dirs[0] == "-" {
+		dirs = nil
+		stdinFlag = true
+	}
+	if stdinFlag || stdin0Flag {
+		read := readPaths
+		if stdin0Flag {
+			read = readNulPaths
+		}
+		paths, err := read(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading directories from stdin: %v\n", err)
+			os.Exit(1)
+		}
+		dirs = append(dirs, paths...)
+	}
+
+	if saveFlag != "" && (checkpointFlag != "" || dryRunFlag || diffFlag || watchFlag > 0 || lowMemoryFlag) {
+		fmt.Fprintln(os.Stderr, "-save keeps every counted file and can't be combined with -checkpoint, -dry-run, -diff, -watch or -low-memory")
+		os.Exit(exitUsage)
+	}
+	if loadFlag != "" && mergeFlag {
+		fmt.Fprintln(os.Stderr, "-load and -merge can't be combined; give every saved run to -merge")
+		os.Exit(exitUsage)
+	}
+	if loadFlag != "" || mergeFlag {
+		mode := "-load"
+		if mergeFlag {
+			mode = "-merge"
+		}
+		if loadFlag != "" && len(dirs) > 0 {
+			fmt.Fprintln(os.Stderr, "-load reports on the directories in the saved run and doesn't take any others")
+			os.Exit(exitUsage)
+		}
+		if mergeFlag && len(dirs) == 0 {
+			fmt.Fprintln(os.Stderr, "-merge needs the files saved with -save as its arguments")
+			os.Exit(exitUsage)
+		}
+		if saveFlag != "" || cacheFlag != "" || checkpointFlag != "" || watchFlag > 0 || diffFlag || repeatFlag > 1 || dryRunFlag ||
+			strictFlag || validateFlag || checkFlag || absFlag || relFlag != "" || dedupeAcrossArgsFlag || patternStatsFlag ||
+			topDirsFlag > 0 || dupesFlag || byMimeFlag || byDepthFlag || byTopFlag || byOwnerFlag || byMountFlag || estimateCompressionFlag || sparseFlag || sparseSummaryFlag || emptyFlag || strictSymlinksFlag {
+			fmt.Fprintln(os.Stderr, mode+" doesn't look at the filesystem, so it can't be combined with -save, -cache, -checkpoint, -watch, -diff, -repeat, -dry-run, "+
+				"-strict, -validate, -check, -abs, -rel, -dedupe-across-args, -pattern-stats, -strict-symlinks or the reports that need more than each file's path, size and time "+
+				"(-top-dirs, -dupes, -by-mime, -by-depth, -by-top, -by-owner, -by-mount, -estimate-compression, -sparse, -sparse-summary and -empty)")
+			os.Exit(exitUsage)
+		}
+		var err error
+		if mergeFlag {
+			loadedSnapshot, err = mergeSnapshots(dirs)
+		} else {
+			loadedSnapshot, err = loadSnapshot(loadFlag)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading %s file: %v\n", mode, err)
+			os.Exit(1)
+		}
+		dirs = loadedSnapshot.paths()
+	}
+	if len(dirs) == 0 {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	if diffFlag && len(dirs) != 2 {
+		fmt.Fprintf(os.Stderr, "-diff needs exactly two directories, got %d\n", len(dirs))
+		os.Exit(exitUsage)
+	}
+	// Check the arguments as given, before -rel changes the working directory
+	invalidArgs := 0
+	if estimateFlag && (jsonFlag || ndjsonFlag || csvFlag || diffFlag || watchFlag > 0 || interactiveFlag || loadFlag != "" || mergeFlag || dryRunFlag) {
+		fmt.Fprintln(os.Stderr, "-estimate only counts entries and can't be combined with -json, -ndjson, -csv, -diff, -watch, -interactive, -load, -merge or -dry-run")
+		os.Exit(exitUsage)
+	}
+	if estimateRateFlag < 1 {
+		fmt.Fprintf(os.Stderr, "Invalid -estimate-rate value %d: must be at least 1\n", estimateRateFlag)
+		os.Exit(exitUsage)
+	}
+	if skipInvalidFlag && !validateFlag {
+		fmt.Fprintln(os.Stderr, "-skip-invalid needs -validate")
+		os.Exit(exitUsage)
+	}
+	if validateFlag {
+		if skipInvalidFlag && diffFlag {
+			fmt.Fprintln(os.Stderr, "-diff needs both directories and can't be combined with -skip-invalid")
+			os.Exit(exitUsage)
+		}
+		valid := validatePaths(dirs)
+		invalidArgs = len(dirs) - len(valid)
+		switch {
+		case invalidArgs > 0 && !skipInvalidFlag:
+			fmt.Fprintf(os.Stderr, "%d of %d arguments can't be measured; not measuring anything (use -skip-invalid to measure the rest)\n", invalidArgs, len(dirs))
+			os.Exit(1)
+		case len(valid) == 0:
+			fmt.Fprintln(os.Stderr, "None of the arguments can be measured")
+			os.Exit(1)
+		}
+		dirs = valid
+	}
+	if absFlag || relFlag != "" {
+		if absFlag && relFlag != "" {
+			fmt.Fprintln(os.Stderr, "-abs and -rel can't be combined")
+			os.Exit(exitUsage)
+		}
+		// The files the flags name are relative to where we were started,
+		// and -rel changes the working directory
+		for _, name := range []*string{&cacheFlag, &checkpointFlag, &baselineFlag, &outputFlag, &saveFlag, &manifestFlag, &verifyFlag, &cpuprofileFlag, &memprofileFlag} {
+			if *name != "" {
+				if abs, err := filepath.Abs(*name); err == nil {
+					*name = abs
+				}
+			}
+		}
+		var err error
+		if dirs, err = relocatePaths(dirs, relFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving paths: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// -estimate is a quick look before a real run, not a run of its own, so
+	// none of what the run needs is set up for it
+	if !estimateFlag {
+		if cacheFlag != "" {
+			dirCache = loadCache(cacheFlag)
+		}
+		if checkpointFlag != "" {
+			runCheckpoint = loadCheckpoint(checkpointFlag)
+		}
+		if manifestFlag != "" || verifyFlag != "" {
+			var err error
+			if hasher, err = startHasher(manifestFlag, verifyFlag); err != nil {
+				fmt.Fprintf(os.Stderr, "Error opening manifest: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if filterStreamFlag {
+			var err error
+			if filterProc, err = startFilterProcess(filterCmdFlag); err != nil {
+				fmt.Fprintf(os.Stderr, "Error starting -filter-cmd: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if baselineFlag != "" {
+			var err error
+			if sizeBaseline, err = loadBaseline(baselineFlag); err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading baseline: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if err := startProfiles(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Only truncate an existing -output file once every flag has been
+	// accepted and the run is set up, so that a typo doesn't wipe the last
+	// report
+	var outputFile *os.File
+	if outputFlag != "" {
+		f, err := os.Create(outputFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating output file: %v\n", err)
+			stopProfiles()
+			os.Exit(1)
+		}
+		outputFile, output = f, f
+	}
+	// Machine-readable output is never colorized
+	colorEnabled = useColor(colorFlag, output) && !jsonFlag && !ndjsonFlag && !csvFlag
+	if barFlag {
+		barWidth = barWidthFor(outputColumns(output))
+	}
+
+	// Ctrl-C stops the walk but still prints what has been measured.  A second
+	// Ctrl-C kills the program outright.
+	interrupted, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-interrupted.Done()
+		stop()
+	}()
+	// -max-runtime stops the run the same way once it's up
+	ctx := interrupted
+	if maxRuntimeFlag > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, maxRuntimeFlag)
+		defer cancel()
+	}
+
+	if estimateFlag {
+		ok := printEstimate(ctx, dirs)
+		if outputFile != nil {
+			if err := outputFile.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing output file: %v\n", err)
+				os.Exit(exitFailure)
+			}
+		}
+		switch {
+		case ctx.Err() != nil:
+			exitCutShort(ctx, "the count is partial")
+		case !ok:
+			os.Exit(exitFailure)
+		}
+		return
+	}
+
+	var ok bool
+	partial := false
+	if diffFlag {
+		ok = runDiff(ctx, dirs[0], dirs[1])
+	} else if watchFlag > 0 {
+		ok, partial = watch(ctx, dirs, watchFlag)
+	} else if watchNotifyFlag {
+		ok, partial = watchChanges(ctx, dirs)
+	} else {
+		ok = processDirectories(ctx, dirs)
+	}
+	if !stopProfiles() {
+		ok = false
+	}
+	// Ctrl-C is how -watch is meant to end, so it only counts as an interruption
+	// if it cut a scan short
+	if watchFlag == 0 && !watchNotifyFlag {
+		partial = ctx.Err() != nil
+	}
+	if invalidArgs > 0 {
+		ok = false
+	}
+	if filterProc != nil {
+		if err := filterProc.close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error running -filter-cmd: %v\n", err)
+			ok = false
+		}
+	}
+	if dirCache != nil {
+		if err := dirCache.save(cacheFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing cache file: %v\n", err)
+			ok = false
+		}
+	}
+	if outputFile != nil {
+		if err := outputFile.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing output file: %v\n", err)
+			os.Exit(exitFailure)
+		}
+	}
+	// Only a run that measured everything is done with its checkpoint
+	if runCheckpoint != nil && ok && !partial {
+		if err := os.Remove(checkpointFlag); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "Warning: couldn't remove checkpoint: %v\n", err)
+		}
+	}
+
+	if partial {
+		exitCutShort(ctx, "totals are partial")
+	}
+	// Errors only make the total smaller, so a total over budget stands
+	if overBudget {
+		os.Exit(exitOverBudget)
+	}
+	if !ok && !ignoreErrorsFlag {
+		os.Exit(exitFailure)
+	}
+	if reportInaccessibleFlag && permissionSkips.Load() > 0 {
+		os.Exit(exitInaccessible)
+	}
 }
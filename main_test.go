@@ -0,0 +1,755 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+/* Give every flag its default, then set the ones a test names, as if they had
+ * been given on the command line
+ * Parameters:
+ *	- t: The test or benchmark
+ *	- args: Command-line flags, like "-recursive" or "-jobs=4"
+ */
+func setFlags(t testing.TB, args ...string) {
+	t.Helper()
+	// flag.Var leaves a variable as it is, so what an earlier test set has to be
+	// cleared.  The first time, the flags are still the testing package's.
+	if flag.CommandLine.Name() == "hello-ford" {
+		flag.VisitAll(func(f *flag.Flag) {
+			reflect.ValueOf(f.Value).Elem().SetZero()
+		})
+	}
+	flag.CommandLine = flag.NewFlagSet("hello-ford", flag.ContinueOnError)
+	defineFlags()
+	if err := flag.CommandLine.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	resetRun()
+	output = io.Discard
+	t.Cleanup(func() { output = os.Stdout })
+}
+
+/* Write files, creating the directories they are in
+ * Parameters:
+ *	- t: The test
+ *	- root: The directory to write them under
+ *	- files: The contents of each file, by slash-separated path below root
+ */
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+/* Generate a tree of arguments, each with a few levels of subdirectories and
+ * files of assorted sizes and extensions
+ * Parameters:
+ *	- t: The test
+ *	- args: How many arguments to generate
+ * Returns:
+ *	- []string: The arguments
+ */
+func generateArgs(t *testing.T, args int) []string {
+	t.Helper()
+	root := t.TempDir()
+	exts := []string{".txt", ".log", ".bin", ""}
+	var dirs []string
+	for a := 0; a < args; a++ {
+		files := make(map[string]string)
+		for n := 0; n < 30; n++ {
+			name := fmt.Sprintf("d%d/e%d/f%d%s", n%3, n%5, n, exts[n%len(exts)])
+			files[name] = strings.Repeat("x", (a*31+n*17)%500)
+		}
+		dir := filepath.Join(root, fmt.Sprintf("arg%02d", a))
+		writeTree(t, dir, files)
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// What a run measured, in a form that can be compared between runs
+type runSummary struct {
+	results []dirResult
+	total   dirResult
+	top     []fileEntry
+	byExt   []groupTotal
+}
+
+/* Measure the arguments and collect what the run found
+ * Parameters:
+ *	- t: The test
+ *	- dirs: The arguments
+ *	- args: The flags to measure them with
+ * Returns:
+ *	- runSummary: The results, total and shared reports
+ */
+func measureWith(t *testing.T, dirs []string, args ...string) runSummary {
+	t.Helper()
+	setFlags(t, args...)
+	results := measureDirectories(context.Background(), dirs)
+	total, ok := printResults(results)
+	if !ok {
+		t.Fatalf("measuring with %v failed: %+v", args, results)
+	}
+	return runSummary{results: results, total: total, top: largest.sorted(), byExt: byExt.sorted()}
+}
+
+/* Run hello-ford in a process of its own, for what only shows from outside:
+ * the exit status and the files left behind
+ * Parameters:
+ *	- t: The test
+ *	- args: The command line
+ * Returns:
+ *	- int: The exit status
+ */
+func runMain(t *testing.T, args ...string) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], append([]string{"-test.run=^TestMainProcess$", "--"}, args...)...)
+	cmd.Env = append(os.Environ(), "HELLO_FORD_MAIN=1")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		t.Logf("hello-ford %s: %s", strings.Join(args, " "), stderr.String())
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return 0
+}
+
+// Not a test: runMain runs the test binary as hello-ford through this
+func TestMainProcess(t *testing.T) {
+	if os.Getenv("HELLO_FORD_MAIN") != "1" {
+		t.Skip("only run by runMain")
+	}
+	args := os.Args[slices.Index(os.Args, "--")+1:]
+	os.Args = append([]string{"hello-ford"}, args...)
+	flag.CommandLine = flag.NewFlagSet("hello-ford", flag.ExitOnError)
+	main()
+	os.Exit(0)
+}
+
+func TestParallelWalksMatchSequential(t *testing.T) {
+	dirs := generateArgs(t, 48)
+	report := []string{"-recursive", "-top=20", "-by-ext", "-stats", "-count-dirs"}
+	want := measureWith(t, dirs, append(report, "-jobs=1")...)
+	if want.total.Files != 48*30 {
+		t.Fatalf("sequential run counted %d files, want %d", want.total.Files, 48*30)
+	}
+	for _, parallel := range [][]string{
+		{"-jobs=64"},
+		{"-jobs=64", "-parallel-args-limit=7"},
+		{"-jobs=3"},
+	} {
+		t.Run(strings.Join(parallel, " "), func(t *testing.T) {
+			got := measureWith(t, dirs, append(report, parallel...)...)
+			if got.total.Size != want.total.Size || got.total.Files != want.total.Files || got.total.Dirs != want.total.Dirs || got.total.Entries != want.total.Entries {
+				t.Errorf("total = %+v, want %+v", got.total, want.total)
+			}
+			for i := range want.results {
+				if got.results[i].Size != want.results[i].Size || got.results[i].Files != want.results[i].Files {
+					t.Errorf("%s = %+v, want %+v", dirs[i], got.results[i], want.results[i])
+				}
+			}
+			if !slices.Equal(got.top, want.top) {
+				t.Errorf("-top = %v, want %v", got.top, want.top)
+			}
+			if !slices.Equal(got.byExt, want.byExt) {
+				t.Errorf("-by-ext = %v, want %v", got.byExt, want.byExt)
+			}
+		})
+	}
+}
+
+/* Make a file of a given size for a test filesystem
+ * Parameters:
+ *	- size: The file's size in bytes
+ * Returns:
+ *	- *fstest.MapFile: The file
+ */
+func mapFile(size int) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(strings.Repeat("x", size)), ModTime: time.Now()}
+}
+
+// A tree with files at several depths, hidden entries and assorted extensions
+var walkFixture = fstest.MapFS{
+	"a.txt":               mapFile(1),
+	"b.log":               mapFile(2),
+	".hidden":             mapFile(4),
+	"sub/c.txt":           mapFile(8),
+	"sub/d.bin":           mapFile(16),
+	"sub/deep/e.txt":      mapFile(32),
+	"sub/deep/er/f.log":   mapFile(64),
+	"cache/g.txt":         mapFile(128),
+	".git/objects/h.pack": mapFile(256),
+	"empty":               &fstest.MapFile{Mode: fs.ModeDir | 0o755},
+}
+
+func TestDirSizeMapFS(t *testing.T) {
+	for _, tc := range []struct {
+		flags []string
+		size  int64
+		files int64
+	}{
+		{nil, 1 + 2 + 4, 3},
+		{[]string{"-recursive"}, 511, 9},
+		{[]string{"-recursive", "-depth=0"}, 1 + 2 + 4, 3},
+		{[]string{"-recursive", "-depth=1"}, 511 - 32 - 64 - 256, 6},
+		{[]string{"-recursive", "-depth=2"}, 511 - 64, 8},
+		{[]string{"-recursive", "-exclude=*.log"}, 511 - 2 - 64, 7},
+		{[]string{"-recursive", "-exclude=deep"}, 511 - 32 - 64, 7},
+		{[]string{"-recursive", "-exclude=*.log", "-exclude=cache"}, 511 - 2 - 64 - 128, 6},
+		{[]string{"-recursive", "-exclude-hidden"}, 511 - 4 - 256, 7},
+		{[]string{"-recursive", "-include=*.txt"}, 1 + 8 + 32 + 128, 4},
+		{[]string{"-recursive", "-include=*.txt", "-exclude=a.txt"}, 8 + 32 + 128, 3},
+		{[]string{"-recursive", "-ignore-case", "-exclude=*.TXT"}, 511 - 1 - 8 - 32 - 128, 5},
+		{[]string{"-recursive", "-no-recurse-into=sub"}, 511 - 8 - 16 - 32 - 64, 5},
+		{[]string{"-recursive", "-min-size=32"}, 32 + 64 + 128 + 256, 4},
+		{[]string{"-recursive", "-max-size=16"}, 1 + 2 + 4 + 8 + 16, 5},
+		{[]string{"-recursive", "-block-size=100"}, 7*100 + 200 + 300, 9},
+	} {
+		t.Run(strings.Join(tc.flags, " "), func(t *testing.T) {
+			setFlags(t, tc.flags...)
+			result, err := dirSize(context.Background(), walkFixture, "root")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Size != tc.size || result.Files != tc.files {
+				t.Errorf("got %d bytes in %d files, want %d in %d", result.Size, result.Files, tc.size, tc.files)
+			}
+		})
+	}
+}
+
+func TestVisitReportsPaths(t *testing.T) {
+	setFlags(t, "-recursive", "-exclude=deep", "-exclude=*.log")
+	top := newTopFiles(20)
+	largest = top
+	t.Cleanup(func() { largest = nil })
+	if _, err := dirSize(context.Background(), walkFixture, "root"); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range top.sorted() {
+		got = append(got, f.Path)
+	}
+	slices.Sort(got)
+	want := []string{"root/.git/objects/h.pack", "root/.hidden", "root/a.txt", "root/cache/g.txt", "root/sub/c.txt", "root/sub/d.bin"}
+	if !slices.Equal(got, want) {
+		t.Errorf("counted %v, want %v", got, want)
+	}
+}
+
+// A filesystem that fails to read one directory
+type failingFS struct {
+	fstest.MapFS
+	bad string // The directory that can't be read
+}
+
+// The error failingFS fails with
+var errInjected = errors.New("injected failure")
+
+func (f failingFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if name == f.bad {
+		return nil, &fs.PathError{Op: "readdirent", Path: name, Err: errInjected}
+	}
+	return f.MapFS.ReadDir(name)
+}
+
+func (f failingFS) Open(name string) (fs.File, error) {
+	if name == f.bad {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errInjected}
+	}
+	return f.MapFS.Open(name)
+}
+
+func TestDirSizePartial(t *testing.T) {
+	// Walked in lexical order, so a.txt and b/ are counted before bad/ fails,
+	// and c.txt never is
+	fsys := failingFS{MapFS: fstest.MapFS{
+		"a.txt":     mapFile(1),
+		"b/x.txt":   mapFile(2),
+		"bad/y.txt": mapFile(4),
+		"c.txt":     mapFile(8),
+	}, bad: "bad"}
+	for _, tc := range []struct {
+		flags []string
+		size  int64
+		files int64
+	}{
+		{[]string{"-recursive"}, 0, 0},
+		{[]string{"-recursive", "-partial"}, 3, 2},
+		{[]string{"-recursive", "-partial", "-readdir-buffer=1"}, 3, 2},
+	} {
+		t.Run(strings.Join(tc.flags, " "), func(t *testing.T) {
+			setFlags(t, tc.flags...)
+			result, err := dirSize(context.Background(), fsys, "root")
+			if !errors.Is(err, errInjected) {
+				t.Fatalf("got error %v, want the injected one", err)
+			}
+			if !strings.Contains(err.Error(), filepath.Join("root", "bad")) {
+				t.Errorf("error %q doesn't name the directory as reached from the argument", err)
+			}
+			if result.Size != tc.size || result.Files != tc.files {
+				t.Errorf("got %d bytes in %d files, want %d in %d", result.Size, result.Files, tc.size, tc.files)
+			}
+		})
+	}
+}
+
+func TestParseHumanSize(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want int64
+	}{
+		{"0", 0},
+		{"1024", 1024},
+		{"1K", 1024},
+		{"1k", 1024},
+		{"1KB", 1024},
+		{"1kb", 1024},
+		{"1KiB", 1024},
+		{"1kib", 1024},
+		{"2.5M", 2621440},
+		{"2.5m", 2621440},
+		{"0.5K", 512},
+		{".5K", 512},
+		{"5.", 5},
+		{"1.5", 2}, // Fractions of a byte are rounded
+		{" 3G ", 3 << 30},
+		{"3 G", 3 << 30},
+		{"1 KB", 1024},
+		{"1T", 1 << 40},
+		{"1P", 1 << 50},
+		{"1E", 1 << 60},
+		{"9223372036854775807", math.MaxInt64},
+	} {
+		if got, err := parseHumanSize(tc.in); err != nil || got != tc.want {
+			t.Errorf("parseHumanSize(%q) = %d, %v, want %d", tc.in, got, err, tc.want)
+		}
+	}
+}
+
+func TestParseHumanSizeRejects(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want string // What the error should say
+	}{
+		{"", "expected a number"},
+		{"abc", "expected a number"},
+		{"5X", "expected a number"},
+		{"K", "expected a number"},
+		{"B", "expected a number"},
+		{"1KK", "expected a number"},
+		{"1iB", "expected a number"},
+		{"1.2.3K", "expected a number"},
+		{"-1K", "expected a number"},
+		{"+1K", "expected a number"},
+		{"inf", "expected a number"},
+		{"0x10", "expected a number"},
+		{"1e3", "expected a number"},
+		{"8E", "too large"},
+		{"9223372036854775808", "too large"},
+	} {
+		_, err := parseHumanSize(tc.in)
+		if err == nil || !strings.Contains(err.Error(), tc.want) || !strings.Contains(err.Error(), strconv.Quote(tc.in)) {
+			t.Errorf("parseHumanSize(%q) error = %v, want one naming the input and saying %q", tc.in, err, tc.want)
+		}
+	}
+}
+
+func TestHumanReadableSizeUnitBases(t *testing.T) {
+	for _, tc := range []struct {
+		size                     int64
+		binary, si, iec, siShort string
+	}{
+		{999, "999 B", "999 B", "999 B", "999"},
+		{1000, "1000 B", "1.0 kB", "1000 B", "1.0k"},
+		{1023, "1023 B", "1.0 kB", "1023 B", "1.0k"},
+		{1024, "1.0 KB", "1.0 kB", "1.0 KiB", "1.0k"},
+		{1536, "1.5 KB", "1.5 kB", "1.5 KiB", "1.5k"},
+		{10240, "10.0 KB", "10.2 kB", "10.0 KiB", "10.2k"},
+		{1 << 20, "1.0 MB", "1.0 MB", "1.0 MiB", "1.0M"},
+		{1 << 40, "1.0 TB", "1.1 TB", "1.0 TiB", "1.1T"},
+		{math.MaxInt64, "8.0 EB", "9.2 EB", "8.0 EiB", "9.2E"},
+	} {
+		setFlags(t, "-human")
+		if got := humanReadableSize(tc.size, unitBase(), unitLabels()); got != tc.binary {
+			t.Errorf("%d = %q, want %q", tc.size, got, tc.binary)
+		}
+		setFlags(t, "-human", "-si")
+		if got := humanReadableSize(tc.size, unitBase(), unitLabels()); got != tc.si {
+			t.Errorf("%d with -si = %q, want %q", tc.size, got, tc.si)
+		}
+		setFlags(t, "-human", "-iec")
+		if got := humanReadableSize(tc.size, unitBase(), unitLabels()); got != tc.iec {
+			t.Errorf("%d with -iec = %q, want %q", tc.size, got, tc.iec)
+		}
+		setFlags(t, "-human", "-si", "-human-short")
+		if got := humanReadableSize(tc.size, unitBase(), unitLabels()); got != tc.siShort {
+			t.Errorf("%d with -si -human-short = %q, want %q", tc.size, got, tc.siShort)
+		}
+	}
+}
+
+func TestExcludeHiddenNested(t *testing.T) {
+	// The argument is hidden itself, but was named, so it is still measured
+	dir := filepath.Join(t.TempDir(), ".dotfiles")
+	writeTree(t, dir, map[string]string{
+		".a":       "1",
+		"b.txt":    "22",
+		".cache/c": "4444",
+		"d/.e/f":   "88888888",
+		"d/g":      strings.Repeat("g", 16),
+		"d/h/.i":   strings.Repeat("i", 32),
+		"d/h/j":    strings.Repeat("j", 64),
+		"d/.k/l/m": strings.Repeat("m", 128),
+	})
+	for _, tc := range []struct {
+		flags []string
+		size  int64
+		files int64
+	}{
+		{[]string{"-recursive"}, 255, 8},
+		{[]string{"-recursive", "-exclude-hidden"}, 2 + 16 + 64, 3},
+		{[]string{"-exclude-hidden"}, 2, 1},
+		{[]string{"-recursive", "-depth=1", "-exclude-hidden"}, 2 + 16, 2},
+	} {
+		t.Run(strings.Join(tc.flags, " "), func(t *testing.T) {
+			setFlags(t, tc.flags...)
+			result, err := measurePath(context.Background(), dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Size != tc.size || result.Files != tc.files {
+				t.Errorf("got %d bytes in %d files, want %d in %d", result.Size, result.Files, tc.size, tc.files)
+			}
+		})
+	}
+	// As is a hidden file named as an argument
+	setFlags(t, "-exclude-hidden")
+	if result, err := measurePath(context.Background(), filepath.Join(dir, ".a")); err != nil || result.Size != 1 {
+		t.Errorf("hidden file argument = %+v, %v, want 1 byte", result, err)
+	}
+}
+
+func TestHumanReadableSizePrecision(t *testing.T) {
+	for _, tc := range []struct {
+		precision int
+		size      int64
+		want      string
+	}{
+		{0, 1023, "1023 B"},
+		{0, 1024, "1 KB"},
+		{0, 1536, "2 KB"},
+		{0, 2560, "2 KB"}, // Halves round to even, as fmt does
+		{0, 1<<20 - 1, "1 MB"},
+		{0, 1 << 30, "1 GB"},
+		{0, 1<<40 - 1, "1 TB"},
+		{1, 1024, "1.0 KB"},
+		{1, 1025, "1.0 KB"},
+		{1, 10239, "10.0 KB"},
+		{1, 1<<20 - 1, "1.0 MB"}, // 1023.999 KB carries into the next unit
+		{1, 1<<30 - 1, "1.0 GB"},
+		{1, 1<<40 - 1, "1.0 TB"},
+		{1, 1<<50 - 1, "1.0 PB"},
+		{1, 1<<60 - 1, "1.0 EB"},
+		{2, 1<<20 - 1, "1.00 MB"},
+		{2, 1536, "1.50 KB"},
+		{3, 1025, "1.001 KB"},
+		{3, 10239, "9.999 KB"},
+		{3, 1<<20 - 1, "1023.999 KB"}, // Precise enough not to carry
+		{3, 1 << 20, "1.000 MB"},
+		{3, 1<<40 - 1, "1.000 TB"},
+	} {
+		setFlags(t, "-human", "-precision="+strconv.Itoa(tc.precision))
+		if got := humanReadableSize(tc.size, unitBase(), unitLabels()); got != tc.want {
+			t.Errorf("%d at -precision %d = %q, want %q", tc.size, tc.precision, got, tc.want)
+		}
+	}
+}
+
+func TestHumanReadableSizeBoundaries(t *testing.T) {
+	setFlags(t, "-human")
+	for _, tc := range []struct {
+		size int64
+		want string
+	}{
+		{0, "0 B"},
+		{1, "1 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KB"},
+		{1025, "1.0 KB"},
+		{1<<20 - 1, "1.0 MB"},
+		{1 << 20, "1.0 MB"},
+		{1<<20 + 1, "1.0 MB"},
+		{1 << 30, "1.0 GB"},
+		{1 << 40, "1.0 TB"},
+		{1 << 50, "1.0 PB"},
+		{1<<60 - 1, "1.0 EB"},
+		{1 << 60, "1.0 EB"},
+		// Past 1024 EB there is no larger prefix, so exabytes keep counting up
+		{math.MaxInt64 / 2, "4.0 EB"},
+		{math.MaxInt64, "8.0 EB"},
+		{-1, "-1 B"},
+		{-1023, "-1023 B"},
+		{-1024, "-1.0 KB"},
+		{-(1 << 60), "-1.0 EB"},
+		{math.MinInt64 + 1, "-8.0 EB"},
+		{math.MinInt64, "-8.0 EB"},
+	} {
+		if got := humanReadableSize(tc.size, unitBase(), unitLabels()); got != tc.want {
+			t.Errorf("humanReadableSize(%d) = %q, want %q", tc.size, got, tc.want)
+		}
+	}
+
+	// -unit E fixes the prefix at the last one there is
+	unitExp = strings.IndexByte("KMGTPE", 'E')
+	t.Cleanup(func() { unitExp = -1 })
+	for _, tc := range []struct {
+		size int64
+		want string
+	}{
+		{0, "0.0 EB"},
+		{1 << 60, "1.0 EB"},
+		{math.MaxInt64, "8.0 EB"},
+		{math.MinInt64, "-8.0 EB"},
+	} {
+		if got := humanReadableSize(tc.size, unitBase(), unitLabels()); got != tc.want {
+			t.Errorf("humanReadableSize(%d) with -unit E = %q, want %q", tc.size, got, tc.want)
+		}
+	}
+}
+
+func TestIgnoreCase(t *testing.T) {
+	fsys := fstest.MapFS{
+		"Photo.JPG":         mapFile(1),
+		"photo2.jpg":        mapFile(2),
+		"Notes.TXT":         mapFile(4),
+		"readme.txt":        mapFile(8),
+		"Cache/x.bin":       mapFile(16),
+		"cache/y.bin":       mapFile(32),
+		"Build/CACHE/z.bin": mapFile(64),
+	}
+	for _, tc := range []struct {
+		flags           []string
+		exact, caseless int64 // Bytes counted without and with -ignore-case
+		types           string
+	}{
+		{[]string{"-exclude=*.jpg"}, 127 - 2, 127 - 1 - 2, ""},
+		{[]string{"-exclude=*.JPG"}, 127 - 1, 127 - 1 - 2, ""},
+		{[]string{"-exclude=CACHE"}, 127 - 64, 127 - 16 - 32 - 64, ""},
+		{[]string{"-include=*.TXT"}, 4, 4 + 8, ""},
+		{[]string{"-include=*.txt", "-exclude=README*"}, 8, 4, ""},
+		{[]string{"-no-recurse-into=cache"}, 127 - 32, 127 - 16 - 32 - 64, ""},
+		// -type always ignores case
+		{nil, 4 + 8, 4 + 8, "TXT"},
+		{nil, 1 + 2, 1 + 2, ".jpg"},
+	} {
+		for _, ignoreCase := range []bool{false, true} {
+			args := append([]string{"-recursive", "-type=" + tc.types}, tc.flags...)
+			want := tc.exact
+			if ignoreCase {
+				args = append(args, "-ignore-case")
+				want = tc.caseless
+			}
+			t.Run(strings.Join(args, " "), func(t *testing.T) {
+				setFlags(t, args...)
+				if tc.types != "" {
+					typeExts = parseTypes(tc.types)
+					t.Cleanup(func() { typeExts = nil })
+				}
+				result, err := dirSize(context.Background(), fsys, "root")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result.Size != want {
+					t.Errorf("got %d bytes, want %d", result.Size, want)
+				}
+			})
+		}
+	}
+}
+
+func TestForEachParallel(t *testing.T) {
+	for _, tc := range []struct{ n, jobs int }{{0, 4}, {1, 1}, {100, 1}, {100, 8}, {3, 64}} {
+		calls := make([]int, tc.n)
+		forEachParallel(tc.n, tc.jobs, func(i int) { calls[i]++ })
+		for i, c := range calls {
+			if c != 1 {
+				t.Errorf("n=%d jobs=%d: item %d handled %d times, want once", tc.n, tc.jobs, i, c)
+			}
+		}
+	}
+}
+
+func TestOutputSurvivesBadSetup(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "aaaa"})
+	good := filepath.Join(t.TempDir(), "report.txt")
+	if status := runMain(t, "-output", good, dir); status != 0 {
+		t.Fatalf("exit status %d measuring %s, want 0", status, dir)
+	}
+	if got, err := os.ReadFile(good); err != nil || !strings.Contains(string(got), "4 bytes") {
+		t.Fatalf("-output wrote %q (%v), want the report", got, err)
+	}
+	missing := filepath.Join(t.TempDir(), "missing", "file")
+	for _, name := range []string{"-baseline", "-verify", "-cpuprofile", "-memprofile"} {
+		t.Run(name, func(t *testing.T) {
+			report := filepath.Join(t.TempDir(), "report.txt")
+			if err := os.WriteFile(report, []byte("last week\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if status := runMain(t, "-output", report, name, missing, dir); status != 1 {
+				t.Errorf("exit status %d, want 1", status)
+			}
+			if got, err := os.ReadFile(report); err != nil || string(got) != "last week\n" {
+				t.Errorf("-output file holds %q (%v), want the earlier report kept", got, err)
+			}
+		})
+	}
+}
+
+func TestRelKeepsFilePaths(t *testing.T) {
+	base := t.TempDir()
+	writeTree(t, base, map[string]string{"a/f.txt": "ffff"})
+	for _, name := range []string{"-save", "-manifest", "-cpuprofile", "-memprofile"} {
+		t.Run(name, func(t *testing.T) {
+			work := t.TempDir()
+			t.Chdir(work)
+			if status := runMain(t, "-rel", base, name, "out", filepath.Join(base, "a")); status != 0 {
+				t.Fatalf("exit status %d, want 0", status)
+			}
+			if _, err := os.Stat(filepath.Join(work, "out")); err != nil {
+				t.Errorf("%s out didn't write to the starting directory: %v", name, err)
+			}
+			if _, err := os.Stat(filepath.Join(base, "out")); err == nil {
+				t.Errorf("%s out wrote to the -rel directory", name)
+			}
+		})
+	}
+}
+
+func TestRelVerifiesStartingManifest(t *testing.T) {
+	base := t.TempDir()
+	writeTree(t, base, map[string]string{"a/f.txt": "ffff"})
+	t.Chdir(t.TempDir())
+	dir := filepath.Join(base, "a")
+	if status := runMain(t, "-rel", base, "-manifest", "m.txt", dir); status != 0 {
+		t.Fatalf("-manifest exit status %d, want 0", status)
+	}
+	// Not the manifest in the starting directory, so verifying against it fails
+	if err := os.WriteFile(filepath.Join(base, "m.txt"), []byte(strings.Repeat("0", 64)+"  4  a/f.txt\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if status := runMain(t, "-rel", base, "-verify", "m.txt", dir); status != 0 {
+		t.Errorf("-verify exit status %d, want 0", status)
+	}
+}
+
+func TestProfilesFinishedOnFailedRun(t *testing.T) {
+	if _, err := exec.LookPath("true"); err != nil {
+		t.Skip("needs true(1) as a filter that answers nothing")
+	}
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "aaaa"})
+	profiles := t.TempDir()
+	cpu, mem := filepath.Join(profiles, "cpu.prof"), filepath.Join(profiles, "mem.prof")
+	if status := runMain(t, "-filter-stream", "-filter-cmd", "true", "-cpuprofile", cpu, "-memprofile", mem, dir); status != exitFailure {
+		t.Fatalf("exit status %d, want %d", status, exitFailure)
+	}
+	for _, name := range []string{cpu, mem} {
+		if info, err := os.Stat(name); err != nil || info.Size() == 0 {
+			t.Errorf("%s wasn't written (%v)", name, err)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for _, tc := range []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 50 * time.Millisecond},
+		{2, 100 * time.Millisecond},
+		{4, 400 * time.Millisecond},
+		{7, 3200 * time.Millisecond},
+		{8, maxRetryDelay},
+		{30, maxRetryDelay},
+		{math.MaxInt, maxRetryDelay},
+	} {
+		if got := retryDelay(tc.attempt); got != tc.want {
+			t.Errorf("retryDelay(%d) = %s, want %s", tc.attempt, got, tc.want)
+		}
+	}
+}
+
+func TestStatWithRetryStopsWhenCancelled(t *testing.T) {
+	setFlags(t, "-retry=30")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	stats := 0
+	start := time.Now()
+	_, err := statWithRetry(ctx, "flaky", func() (fs.FileInfo, error) {
+		stats++
+		return nil, syscall.EIO
+	})
+	if !errors.Is(err, syscall.EIO) {
+		t.Errorf("statWithRetry returned %v, want the last EIO", err)
+	}
+	// The 20ms deadline falls in the first 50ms wait
+	if stats != 1 {
+		t.Errorf("stat called %d times, want once", stats)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("statWithRetry took %s after being cancelled", elapsed)
+	}
+}
+
+// Run with go test -bench WalkReaddirBuffer, and TMPDIR set to try another
+// filesystem
+func BenchmarkWalkReaddirBuffer(b *testing.B) {
+	dir := b.TempDir()
+	for n := range 100000 {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("f%06d", n)), nil, 0o644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	for _, buffer := range []int{0, 16, 256, 4096, 65536} {
+		b.Run(fmt.Sprintf("readdir-buffer=%d", buffer), func(b *testing.B) {
+			setFlags(b, fmt.Sprintf("-readdir-buffer=%d", buffer))
+			for b.Loop() {
+				if _, err := measurePath(context.Background(), dir); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
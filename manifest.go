@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A file's line in a -manifest file
+type manifestEntry struct {
+	hash string
+	size int64
+}
+
+// What -verify found had changed since the manifest was written
+type manifestChanges struct {
+	Changed []string `json:"changed"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+// A file waiting to be hashed for -manifest or -verify
+type manifestJob struct {
+	path string
+	size int64
+}
+
+// Hashes the counted files as the walk finds them, for -manifest and -verify
+type manifestHasher struct {
+	jobs chan manifestJob
+	wg   sync.WaitGroup
+
+	mu      sync.Mutex               // Guards everything below, which the workers update
+	file    *os.File                 // The new manifest, with -manifest
+	out     *bufio.Writer            // Buffers writes to file
+	want    map[string]manifestEntry // The files in the -verify manifest not yet found
+	changes manifestChanges
+	failed  bool // A file couldn't be hashed or the manifest couldn't be written
+}
+
+// The hasher for this run, or nil without -manifest or -verify
+var hasher *manifestHasher
+
+/* Read a manifest written by -manifest
+ * Parameters:
+ *	- name: Path of the file
+ * Returns:
+ *	- (map[string]manifestEntry, error): Each file's hash and size by path, or an
+ *	  error if the file can't be read or a line isn't "hash  size  path"
+ */
+func readManifest(name string) (map[string]manifestEntry, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := make(map[string]manifestEntry)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		hash, rest, ok := strings.Cut(scanner.Text(), "  ")
+		size, p, ok2 := strings.Cut(rest, "  ")
+		bytes, err := strconv.ParseInt(size, 10, 64)
+		if !ok || !ok2 || err != nil || len(hash) != 64 {
+			return nil, fmt.Errorf("%s:%d: expected \"hash  size  path\"", name, n)
+		}
+		entries[p] = manifestEntry{hash: hash, size: bytes}
+	}
+	return entries, scanner.Err()
+}
+
+/* Start hashing files for -manifest and -verify, with a pool of -jobs workers
+ * Parameters:
+ *	- manifest: The manifest to write, or ""
+ *	- verify: The manifest to compare against, or ""
+ * Returns:
+ *	- (*manifestHasher, error): The hasher, or an error if either file can't be
+ *	  used
+ */
+func startHasher(manifest, verify string) (*manifestHasher, error) {
+	h := &manifestHasher{jobs: make(chan manifestJob, jobsFlag)}
+	if verify != "" {
+		var err error
+		if h.want, err = readManifest(verify); err != nil {
+			return nil, err
+		}
+		// Listed even when empty, so JSON output always has all three
+		h.changes = manifestChanges{Changed: []string{}, Added: []string{}, Removed: []string{}}
+	}
+	if manifest != "" {
+		// Write a new file and rename it over the old one once every file is
+		// hashed, so a failed run doesn't lose an earlier manifest, which may
+		// also be the one being verified against
+		f, err := os.Create(manifest + ".tmp")
+		if err != nil {
+			return nil, err
+		}
+		h.file, h.out = f, bufio.NewWriter(f)
+	}
+	for n := 0; n < jobsFlag; n++ {
+		h.wg.Add(1)
+		go h.work()
+	}
+	return h, nil
+}
+
+/* Queue a counted file to be hashed.  This blocks while every worker is busy,
+ * so the walk can't get far ahead of the hashing.
+ * Parameters:
+ *	- p: Path of the file
+ *	- size: The file's apparent size
+ */
+func (h *manifestHasher) add(p string, size int64) {
+	h.jobs <- manifestJob{p, size}
+}
+
+/* Hash queued files until there are no more
+ */
+func (h *manifestHasher) work() {
+	defer h.wg.Done()
+	for job := range h.jobs {
+		sum, err := hashFile(job.path)
+		h.mu.Lock()
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "Error hashing %s: %v\n", job.path, err)
+			h.failed = true
+			// It's there, so it isn't one of the files removed since the manifest
+			delete(h.want, job.path)
+		default:
+			h.record(job, sum)
+		}
+		h.mu.Unlock()
+	}
+}
+
+/* Write a hashed file to the manifest and compare it with the one being
+ * verified.  h.mu must be held.
+ * Parameters:
+ *	- job: The file
+ *	- sum: Its hex-encoded SHA-256
+ */
+func (h *manifestHasher) record(job manifestJob, sum string) {
+	if h.out != nil {
+		if _, err := fmt.Fprintf(h.out, "%s  %d  %s\n", sum, job.size, job.path); err != nil && !h.failed {
+			fmt.Fprintf(os.Stderr, "Error writing -manifest file: %v\n", err)
+			h.failed = true
+		}
+	}
+	if h.want == nil {
+		return
+	}
+	old, ok := h.want[job.path]
+	switch {
+	case !ok:
+		h.changes.Added = append(h.changes.Added, job.path)
+	case old.hash != sum || old.size != job.size:
+		h.changes.Changed = append(h.changes.Changed, job.path)
+	}
+	delete(h.want, job.path)
+}
+
+/* Wait for every queued file to be hashed and finish the manifest
+ * Parameters:
+ *	- manifest: The manifest being written, or ""
+ * Returns:
+ *	- bool: false if a file couldn't be hashed or the manifest couldn't be
+ *	  written
+ */
+func (h *manifestHasher) finish(manifest string) bool {
+	close(h.jobs)
+	h.wg.Wait()
+	if h.file != nil {
+		err := h.out.Flush()
+		if closeErr := h.file.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil && !h.failed {
+			err = os.Rename(manifest+".tmp", manifest)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing -manifest file: %v\n", err)
+			h.failed = true
+		}
+		if h.failed {
+			os.Remove(manifest + ".tmp")
+		}
+	}
+	if h.want != nil {
+		for p := range h.want {
+			h.changes.Removed = append(h.changes.Removed, p)
+		}
+		slices.Sort(h.changes.Changed)
+		slices.Sort(h.changes.Added)
+		slices.Sort(h.changes.Removed)
+	}
+	return !h.failed
+}
+
+/* Check whether -verify found any differences
+ * Returns:
+ *	- bool: true if a file was changed, added or removed
+ */
+func (c *manifestChanges) any() bool {
+	return len(c.Changed)+len(c.Added)+len(c.Removed) > 0
+}
+
+/* Print what -verify found after the totals
+ * Parameters:
+ *	- c: The differences from the manifest
+ */
+func printManifestChanges(c *manifestChanges) {
+	printHeading("Compared with " + verifyFlag + ":")
+	for _, group := range []struct {
+		verdict string
+		paths   []string
+	}{{"changed", c.Changed}, {"added", c.Added}, {"removed", c.Removed}} {
+		for _, p := range group.paths {
+			printRecord(group.verdict + ": " + p)
+		}
+	}
+	printRecord(fmt.Sprintf("%d changed, %d added, %d removed", len(c.Changed), len(c.Added), len(c.Removed)))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+)
+
+// Collects files during the walk so their content can be sniffed afterwards, for
+// -by-mime
+type mimeSniffer struct {
+	paths []string
+	sizes map[string]int64 // The size each file contributed to the total
+}
+
+/* Create an empty MIME type sniffer
+ * Returns:
+ *	- *mimeSniffer: A sniffer with no files
+ */
+func newMimeSniffer() *mimeSniffer {
+	return &mimeSniffer{sizes: make(map[string]int64)}
+}
+
+/* Record a counted file
+ * Parameters:
+ *	- p: Path of the file
+ *	- size: The size the file contributed to the total
+ */
+func (m *mimeSniffer) add(p string, size int64) {
+	m.paths = append(m.paths, p)
+	m.sizes[p] = size
+}
+
+/* Sniff every file and total them by MIME type
+ * Returns:
+ *	- []groupTotal: The MIME types, largest first.  Files that couldn't be read
+ *	  are reported on stderr and grouped under "(unknown)".
+ */
+func (m *mimeSniffer) sorted() []groupTotal {
+	paths := append([]string(nil), m.paths...)
+	sort.Strings(paths)
+	types := sniffFiles(paths)
+	b := make(breakdown)
+	for i, p := range paths {
+		b.add(types[i], m.sizes[p])
+	}
+	return b.sorted()
+}
+
+/* Detect the MIME types of files using a pool of -jobs workers
+ * Parameters:
+ *	- paths: The files to sniff
+ * Returns:
+ *	- []string: Each file's MIME type, in the same order as paths, or "(unknown)"
+ *	  for files that couldn't be read.  Those are reported on stderr, also in
+ *	  order.
+ */
+func sniffFiles(paths []string) []string {
+	types := make([]string, len(paths))
+	errs := make([]error, len(paths))
+	forEachParallel(len(paths), jobsFlag, func(i int) {
+		types[i], errs[i] = sniffFile(paths[i])
+	})
+
+	for i, err := range errs {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error sniffing %s: %v\n", paths[i], err)
+			types[i] = "(unknown)"
+		}
+	}
+	return types
+}
+
+/* Detect a file's MIME type from its first 512 bytes
+ * Parameters:
+ *	- p: Path of the file
+ * Returns:
+ *	- (string, error): The type, as reported by http.DetectContentType, or an
+ *	  error if the file couldn't be read
+ */
+func sniffFile(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	// DetectContentType never looks past the first 512 bytes
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
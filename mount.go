@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// A line of the mount table
+type mountEntry struct {
+	source string // What is mounted, like /dev/sda1 or server:/export
+	point  string
+}
+
+// Mount points for -by-mount, longest first so the innermost mount matches first
+var mountPoints []string
+
+// Device IDs from -exclude-device, with the name each was given as
+var excludedDevices map[uint64]string
+
+/* Load the mount points for -by-mount
+ * Returns:
+ *  - error: An error if the mount table couldn't be read
+ */
+func setupMountPoints() error {
+	points, err := loadMountPoints()
+	if err != nil {
+		return err
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return len(points[i]) > len(points[j])
+	})
+	mountPoints = points
+	return nil
+}
+
+/* Get the key a file is grouped under for -by-mount
+ * Parameters:
+ *  - p: The file's path
+ * Returns:
+ *  - string: The mount point the file is on, or "(unknown)" if it can't be found
+ */
+func mountKey(p string) string {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "(unknown)"
+	}
+	for _, point := range mountPoints {
+		if abs == point || isInside(abs, point) {
+			return point
+		}
+	}
+	return "(unknown)"
+}
+
+/* Find the device IDs of the -exclude-device names
+ * Parameters:
+ *  - names: Each a device ID, either as a number or as major:minor, or the
+ *    source or mount point of an entry in the mount table, like /dev/sdb1,
+ *    server:/export or /mnt/share
+ * Returns:
+ *  - (map[uint64]string, error): The device IDs, or an error naming one that
+ *    isn't mounted
+ */
+func resolveExcludedDevices(names []string) (map[uint64]string, error) {
+	devs := make(map[uint64]string)
+	var mounts []mountEntry
+	loaded := false
+	for _, name := range names {
+		if dev, err := strconv.ParseUint(name, 10, 64); err == nil {
+			devs[dev] = name
+			continue
+		}
+		if dev, ok := parseDeviceNumber(name); ok {
+			devs[dev] = name
+			continue
+		}
+		if !loaded {
+			var err error
+			if mounts, err = loadMounts(); err != nil {
+				return nil, fmt.Errorf("can't look up %s: %w", name, err)
+			}
+			loaded = true
+		}
+		// A source can be mounted in several places, bind mounts included
+		found := false
+		for _, m := range mounts {
+			if m.source != name && m.point != name {
+				continue
+			}
+			info, err := os.Stat(m.point)
+			if err != nil {
+				continue
+			}
+			if dev, ok := deviceID(info); ok {
+				devs[dev] = name
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s is not a mounted device or mount point", name)
+		}
+	}
+	return devs, nil
+}
@@ -0,0 +1,95 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The mount table can be read from /proc/mounts on this platform
+const mountTableSupported = true
+
+/* Read the mount points from /proc/mounts
+ * Returns:
+ *  - ([]string, error): Every mount point, or an error if the table couldn't be read
+ */
+func loadMountPoints() ([]string, error) {
+	mounts, err := loadMounts()
+	if err != nil {
+		return nil, err
+	}
+	points := make([]string, len(mounts))
+	for i, m := range mounts {
+		points[i] = m.point
+	}
+	return points, nil
+}
+
+/* Read the mount table from /proc/mounts
+ * Returns:
+ *  - ([]mountEntry, error): Every mount, or an error if the table couldn't be read
+ */
+func loadMounts() ([]mountEntry, error) {
+	f, err := os.Open("/proc/mounts")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mounts []mountEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		mounts = append(mounts, mountEntry{source: unescapeMountPath(fields[0]), point: unescapeMountPath(fields[1])})
+	}
+	return mounts, scanner.Err()
+}
+
+/* Parse a device number written as major:minor, as in /proc/self/mountinfo
+ * Parameters:
+ *  - s: The device number, like "8:1"
+ * Returns:
+ *  - (uint64, bool): The device ID as stat reports it, and false if s isn't in
+ *    that form
+ */
+func parseDeviceNumber(s string) (uint64, bool) {
+	majorText, minorText, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, false
+	}
+	major, err1 := strconv.ParseUint(majorText, 10, 32)
+	minor, err2 := strconv.ParseUint(minorText, 10, 32)
+	if err1 != nil || err2 != nil {
+		return 0, false
+	}
+	// The same encoding as the kernel's new_encode_dev and glibc's makedev
+	return (minor & 0xff) | (major&0xfff)<<8 | (minor&^0xff)<<12 | (major&^0xfff)<<32, true
+}
+
+/* Undo the octal escapes /proc/mounts uses for spaces, tabs, newlines and
+ * backslashes in paths
+ * Parameters:
+ *  - s: The path as it appears in the table, like "/mnt/my\040disk"
+ * Returns:
+ *  - string: The real path
+ */
+func unescapeMountPath(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
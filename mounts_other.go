@@ -0,0 +1,34 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// There's no mount table to read on this platform, so -by-mount is a no-op
+const mountTableSupported = false
+
+/* The mount table isn't available on this platform
+ * Returns:
+ *  - ([]string, error): Always an error
+ */
+func loadMountPoints() ([]string, error) {
+	return nil, errors.New("no mount table on this platform")
+}
+
+/* The mount table isn't available on this platform
+ * Returns:
+ *  - ([]mountEntry, error): Always an error
+ */
+func loadMounts() ([]mountEntry, error) {
+	return nil, errors.New("no mount table on this platform")
+}
+
+/* Device numbers aren't written as major:minor on this platform
+ * Parameters:
+ *  - s: The device number
+ * Returns:
+ *  - (uint64, bool): Always false
+ */
+func parseDeviceNumber(s string) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Keeps -ndjson lines from different workers from interleaving
+var ndjsonMu sync.Mutex
+
+// The last line of -ndjson output
+type ndjsonTotal struct {
+	Total      int64 `json:"total"`
+	TotalFiles int64 `json:"totalFiles"`
+}
+
+/* Write one directory's result as a line of -ndjson output, as soon as it's done
+ * Parameters:
+ *	- r: The result, which is left out by -summary or -threshold unless it failed
+ */
+func streamResult(r dirResult) {
+	if r.Error == "" && (summaryFlag || !withinThreshold(r.Size)) {
+		return
+	}
+	writeNDJSON(encodeResultPaths(r))
+}
+
+/* Write the total as the last line of -ndjson output
+ * Parameters:
+ *	- total: Cumulative totals of all directories
+ */
+func streamTotal(total dirResult) {
+	writeNDJSON(ndjsonTotal{Total: total.Size, TotalFiles: total.Files})
+}
+
+/* Write a value as one line of JSON
+ * Parameters:
+ *	- v: The value to write
+ */
+func writeNDJSON(v any) {
+	ndjsonMu.Lock()
+	defer ndjsonMu.Unlock()
+	if err := json.NewEncoder(output).Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing JSON: %v\n", err)
+		exitDuringRun(1)
+	}
+}
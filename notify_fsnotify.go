@@ -0,0 +1,129 @@
+//go:build fsnotify
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Directories can be watched with fsnotify, on whatever it supports
+const notifySupported = true
+
+// Watches directories with fsnotify, for -watch-fsnotify
+type dirNotifier struct {
+	w *fsnotify.Watcher
+
+	mu   sync.Mutex
+	dirs map[string]bool // Every watched directory
+}
+
+/* Start an fsnotify watcher with nothing watched yet
+ * Returns:
+ *  - (*dirNotifier, error): The notifier, or an error if this platform has no
+ *    filesystem notifications
+ */
+func newDirNotifier() (*dirNotifier, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return &dirNotifier{w: w, dirs: make(map[string]bool)}, nil
+}
+
+/* Start watching a directory's own entries, not its subdirectories'
+ * Parameters:
+ *  - dir: Path of the directory
+ * Returns:
+ *  - error: An error if the watch couldn't be added, such as when
+ *    fs.inotify.max_user_watches is used up
+ */
+func (n *dirNotifier) watch(dir string) error {
+	if err := n.w.Add(dir); err != nil {
+		return &os.PathError{Op: "watch", Path: dir, Err: err}
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.dirs[dir] = true
+	return nil
+}
+
+/* Stop watching a directory and everything below it, once it has been removed,
+ * moved away or has to be walked again
+ * Parameters:
+ *  - dir: Path of the directory
+ */
+func (n *dirNotifier) unwatch(dir string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for p := range n.dirs {
+		if p == dir || strings.HasPrefix(p, dir+string(filepath.Separator)) {
+			// fsnotify has already dropped the watch if the directory is gone
+			n.w.Remove(p)
+			delete(n.dirs, p)
+		}
+	}
+}
+
+/* Turn an fsnotify event into the change it makes to a watched directory
+ * Parameters:
+ *  - event: The event
+ * Returns:
+ *  - dirChange: The change.  fsnotify doesn't say what kind of entry it was, so
+ *    one that still exists is looked at, and one that's gone was a directory if
+ *    it was being watched.
+ */
+func (n *dirNotifier) change(event fsnotify.Event) dirChange {
+	c := dirChange{dir: filepath.Dir(event.Name), name: filepath.Base(event.Name)}
+	if info, err := os.Lstat(event.Name); err == nil {
+		c.isDir = info.IsDir()
+	} else {
+		c.isDir = n.dirs[event.Name]
+	}
+	return c
+}
+
+/* Wait for the next change to the watched directories
+ * Returns:
+ *  - ([]dirChange, error): The change read, or an error once the notifier is
+ *    closed.  Lost events, or any other error fsnotify reports, come back as a
+ *    single change with overflow set.
+ */
+func (n *dirNotifier) read() ([]dirChange, error) {
+	select {
+	case event, ok := <-n.w.Events:
+		if !ok {
+			return nil, os.ErrClosed
+		}
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		return []dirChange{n.change(event)}, nil
+	case _, ok := <-n.w.Errors:
+		if !ok {
+			return nil, os.ErrClosed
+		}
+		return []dirChange{{overflow: true}}, nil
+	}
+}
+
+/* Stop watching, ending any read in progress
+ */
+func (n *dirNotifier) close() {
+	n.w.Close()
+}
+
+/* Check whether an error from watch means there are no watches left
+ * Parameters:
+ *  - err: The error
+ * Returns:
+ *  - bool: true if fs.inotify.max_user_watches has been reached
+ */
+func outOfWatches(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
@@ -0,0 +1,138 @@
+//go:build linux && !fsnotify
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// Directories can be watched with inotify on this platform
+const notifySupported = true
+
+// The changes that make a watched directory worth measuring again
+const notifyEvents = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_ATTRIB |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ONLYDIR | syscall.IN_DONT_FOLLOW
+
+// Watches directories with inotify, for -watch-fsnotify
+type dirNotifier struct {
+	f *os.File // The inotify instance, non-blocking so closing it ends a read
+
+	mu   sync.Mutex
+	dirs map[int32]string // Each watched directory by watch descriptor
+	wds  map[string]int32 // Each watch descriptor by directory
+}
+
+/* Start an inotify instance with nothing watched yet
+ * Returns:
+ *  - (*dirNotifier, error): The notifier, or an error if inotify isn't available
+ */
+func newDirNotifier() (*dirNotifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	return &dirNotifier{
+		f:    os.NewFile(uintptr(fd), "inotify"),
+		dirs: make(map[int32]string),
+		wds:  make(map[string]int32),
+	}, nil
+}
+
+/* Start watching a directory's own entries, not its subdirectories'
+ * Parameters:
+ *  - dir: Path of the directory
+ * Returns:
+ *  - error: An error if the watch couldn't be added, such as when
+ *    fs.inotify.max_user_watches is used up
+ */
+func (n *dirNotifier) watch(dir string) error {
+	wd, err := syscall.InotifyAddWatch(int(n.f.Fd()), dir, notifyEvents)
+	if err != nil {
+		return &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.dirs[int32(wd)] = dir
+	n.wds[dir] = int32(wd)
+	return nil
+}
+
+/* Stop watching a directory and everything below it, once it has been removed,
+ * moved away or has to be walked again
+ * Parameters:
+ *  - dir: Path of the directory
+ */
+func (n *dirNotifier) unwatch(dir string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for p, wd := range n.wds {
+		if p == dir || strings.HasPrefix(p, dir+string(filepath.Separator)) {
+			// The kernel has already dropped the watch if the directory is gone
+			syscall.InotifyRmWatch(int(n.f.Fd()), uint32(wd))
+			delete(n.wds, p)
+			delete(n.dirs, wd)
+		}
+	}
+}
+
+/* Wait for the next changes to the watched directories
+ * Returns:
+ *  - ([]dirChange, error): The changes read, or an error once the notifier is
+ *    closed.  A lost event queue is reported as a single change with overflow set.
+ */
+func (n *dirNotifier) read() ([]dirChange, error) {
+	buf := make([]byte, 64*1024)
+	size, err := n.f.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var changes []dirChange
+	for off := 0; off+syscall.SizeofInotifyEvent <= size; {
+		event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+		name := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(event.Len)]
+		off += syscall.SizeofInotifyEvent + int(event.Len)
+		if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+			return []dirChange{{overflow: true}}, nil
+		}
+		dir, ok := n.dirs[event.Wd]
+		if !ok {
+			continue
+		}
+		if event.Mask&syscall.IN_IGNORED != 0 {
+			delete(n.dirs, event.Wd)
+			delete(n.wds, dir)
+			continue
+		}
+		changes = append(changes, dirChange{
+			dir:   dir,
+			name:  string(bytes.TrimRight(name, "\x00")),
+			isDir: event.Mask&syscall.IN_ISDIR != 0,
+		})
+	}
+	return changes, nil
+}
+
+/* Stop watching, ending any read in progress
+ */
+func (n *dirNotifier) close() {
+	n.f.Close()
+}
+
+/* Check whether an error from watch means there are no inotify watches left
+ * Parameters:
+ *  - err: The error
+ * Returns:
+ *  - bool: true if fs.inotify.max_user_watches has been reached
+ */
+func outOfWatches(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
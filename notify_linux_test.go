@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+/* Apply the notifier's changes to a watched argument until its result is the
+ * one wanted, or give up after a few seconds
+ * Parameters:
+ *	- t: The test
+ *	- a: The argument
+ *	- n: The notifier watching it
+ *	- changes: The notifier's batches of changes
+ *	- size: The total wanted
+ *	- files: The file count wanted
+ */
+func waitForResult(t *testing.T, a *watchedArg, n *dirNotifier, changes <-chan []dirChange, size, files int64) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for {
+		if got := a.result(); got.Error == "" && got.Size == size && got.Files == files {
+			return
+		}
+		batch := waitForChanges(ctx, changes)
+		if batch == nil {
+			got := a.result()
+			t.Fatalf("result = %d bytes in %d files (%q), want %d in %d", got.Size, got.Files, got.Error, size, files)
+		}
+		if a.update(context.Background(), n, batch) {
+			a.scan(context.Background(), n)
+		}
+	}
+}
+
+func TestWatchNotifyUpdates(t *testing.T) {
+	setFlags(t, "-watch-fsnotify", "-recursive")
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "aaaa"})
+	n, err := newDirNotifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := make(chan []dirChange)
+	go func() {
+		defer close(changes)
+		for {
+			batch, err := n.read()
+			if err != nil {
+				return
+			}
+			changes <- batch
+		}
+	}()
+	defer func() {
+		n.close()
+		for range changes {
+		}
+	}()
+
+	a := &watchedArg{path: dir, root: filepath.Clean(dir)}
+	a.scan(context.Background(), n)
+	waitForResult(t, a, n, changes, 4, 1)
+
+	// A new subdirectory is walked with whatever is already in it
+	writeTree(t, dir, map[string]string{"sub/b.txt": "bb", "sub/deeper/c.txt": "ccc"})
+	waitForResult(t, a, n, changes, 9, 3)
+
+	// A file changing in it only measures that directory again
+	if err := os.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("bbbbbbb"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitForResult(t, a, n, changes, 14, 3)
+	if err := os.WriteFile(filepath.Join(dir, "sub", "deeper", "d.txt"), []byte("d"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitForResult(t, a, n, changes, 15, 4)
+
+	// Removing it drops everything that was below it
+	if err := os.RemoveAll(filepath.Join(dir, "sub")); err != nil {
+		t.Fatal(err)
+	}
+	waitForResult(t, a, n, changes, 4, 1)
+	for p := range a.own {
+		if p != a.root {
+			t.Errorf("%s is still kept after its tree was removed", p)
+		}
+	}
+}
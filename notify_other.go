@@ -0,0 +1,57 @@
+//go:build !linux && !fsnotify
+
+package main
+
+import "errors"
+
+// There's no inotify on this platform, so -watch-fsnotify isn't available
+const notifySupported = false
+
+// Stands in for the inotify notifier on platforms without one
+type dirNotifier struct{}
+
+/* Directories can't be watched on this platform
+ * Returns:
+ *  - (*dirNotifier, error): Always an error
+ */
+func newDirNotifier() (*dirNotifier, error) {
+	return nil, errors.New("no filesystem notifications on this platform")
+}
+
+/* Directories can't be watched on this platform
+ * Parameters:
+ *  - dir: Unused
+ * Returns:
+ *  - error: Always an error
+ */
+func (n *dirNotifier) watch(dir string) error {
+	return errors.New("no filesystem notifications on this platform")
+}
+
+/* Nothing is watched on this platform
+ * Parameters:
+ *  - dir: Unused
+ */
+func (n *dirNotifier) unwatch(dir string) {}
+
+/* Directories can't be watched on this platform
+ * Returns:
+ *  - ([]dirChange, error): Always an error
+ */
+func (n *dirNotifier) read() ([]dirChange, error) {
+	return nil, errors.New("no filesystem notifications on this platform")
+}
+
+/* Nothing is watched on this platform
+ */
+func (n *dirNotifier) close() {}
+
+/* There are no watches to run out of on this platform
+ * Parameters:
+ *  - err: Unused
+ * Returns:
+ *  - bool: Always false
+ */
+func outOfWatches(err error) bool {
+	return false
+}
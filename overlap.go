@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/* Resolve an argument to the path it is measured at
+ * Parameters:
+ *  - p: The argument
+ * Returns:
+ *  - string: The absolute path with symlinks resolved.  A symlinked argument is
+ *    only resolved itself with -follow-top-level, since otherwise the link is what
+ *    gets measured.
+ */
+func canonicalPath(p string) string {
+	if isRemote(p) {
+		return p
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return filepath.Clean(p)
+	}
+	if info, err := os.Lstat(abs); err == nil && info.Mode()&fs.ModeSymlink != 0 && !followTopLevelFlag {
+		if dir, err := filepath.EvalSymlinks(filepath.Dir(abs)); err == nil {
+			return filepath.Join(dir, filepath.Base(abs))
+		}
+		return abs
+	}
+	if real, err := filepath.EvalSymlinks(abs); err == nil {
+		return real
+	}
+	return abs
+}
+
+/* Find the arguments whose contents are already counted by another argument
+ * Parameters:
+ *  - paths: The arguments, in order
+ * Returns:
+ *  - []string: For each argument, the earlier argument it repeats or, with
+ *    -recursive, the argument it is nested inside.  Empty if it doesn't overlap.
+ */
+func findOverlaps(paths []string) []string {
+	canon := make([]string, len(paths))
+	for i, p := range paths {
+		// Compared in NFC with -normalize-unicode, since on macOS the same
+		// directory can be given composed in one argument and decomposed in another
+		canon[i] = normalizeName(canonicalPath(p))
+	}
+
+	overlaps := make([]string, len(paths))
+	for i := range paths {
+		for j := range paths {
+			if i == j {
+				continue
+			}
+			same := canon[i] == canon[j] && j < i
+			if same || (recursiveFlag && isInside(canon[i], canon[j])) {
+				overlaps[i] = paths[j]
+				break
+			}
+		}
+	}
+	return overlaps
+}
+
+/* Check whether a path is strictly below a directory
+ * Parameters:
+ *  - p: The path, absolute and clean
+ *  - dir: The directory, absolute and clean
+ * Returns:
+ *  - bool: true if p is inside dir
+ */
+func isInside(p, dir string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
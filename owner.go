@@ -0,0 +1,34 @@
+package main
+
+import (
+	"io/fs"
+	"os/user"
+	"strconv"
+)
+
+// Usernames already looked up for -by-owner, keyed by UID.  Guarded by reportMu
+// like the reports it feeds
+var ownerNames = make(map[uint32]string)
+
+/* Get the key a file is grouped under for -by-owner
+ * Parameters:
+ *  - info: File info for the file
+ * Returns:
+ *  - string: The owner's username, the numeric UID if it has no name, or
+ *    "(unknown)" if the owner can't be determined
+ */
+func ownerKey(info fs.FileInfo) string {
+	uid, ok := fileOwner(info)
+	if !ok {
+		return "(unknown)"
+	}
+	if name, ok := ownerNames[uid]; ok {
+		return name
+	}
+	name := strconv.FormatUint(uint64(uid), 10)
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+	ownerNames[uid] = name
+	return name
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/base64"
+	"slices"
+	"strings"
+)
+
+/* Encode a path for -json, -ndjson or -csv output, as -path-encoding asks
+ * Parameters:
+ *	- p: The path, or a name taken from one, as read from the filesystem
+ * Returns:
+ *	- string: p with any bytes that aren't valid UTF-8 replaced by U+FFFD for
+ *	  utf8, p base64-encoded for base64, or p unchanged for raw
+ */
+func encodePath(p string) string {
+	switch pathEncodingFlag {
+	case "base64":
+		return base64.StdEncoding.EncodeToString([]byte(p))
+	case "raw":
+		return p
+	}
+	return strings.ToValidUTF8(p, "\uFFFD")
+}
+
+/* Encode every path in a list
+ * Parameters:
+ *	- paths: The paths, which are left as they are
+ * Returns:
+ *	- []string: A copy with each path passed through encodePath
+ */
+func encodePaths(paths []string) []string {
+	if paths == nil {
+		return nil
+	}
+	encoded := make([]string, len(paths))
+	for i, p := range paths {
+		encoded[i] = encodePath(p)
+	}
+	return encoded
+}
+
+/* Encode the paths in a directory's result
+ * Parameters:
+ *	- r: The result, which is left as it is, since -save and -diff may still need it
+ * Returns:
+ *	- dirResult: A copy with its path, the argument it overlaps and any -tree
+ *	  outline encoded
+ */
+func encodeResultPaths(r dirResult) dirResult {
+	r.Path = encodePath(r.Path)
+	if r.Overlaps != "" {
+		r.Overlaps = encodePath(r.Overlaps)
+	}
+	if r.Tree != nil {
+		r.Tree = encodeTreePaths(r.Tree)
+	}
+	return r
+}
+
+/* Encode the paths in a -tree outline
+ * Parameters:
+ *	- n: The root of the (sub)tree, which is left as it is
+ * Returns:
+ *	- *dirNode: A copy of the tree with every path encoded
+ */
+func encodeTreePaths(n *dirNode) *dirNode {
+	c := *n
+	c.Path = encodePath(n.Path)
+	c.Children = make([]*dirNode, len(n.Children))
+	for i, child := range n.Children {
+		c.Children[i] = encodeTreePaths(child)
+	}
+	return &c
+}
+
+/* Encode the keys of a breakdown's groups, for the breakdowns grouped by part
+ * of a path
+ * Parameters:
+ *	- groups: The groups, which are left as they are
+ * Returns:
+ *	- []groupTotal: A copy with each key encoded
+ */
+func encodeGroupKeys(groups []groupTotal) []groupTotal {
+	groups = slices.Clone(groups)
+	for i := range groups {
+		groups[i].Key = encodePath(groups[i].Key)
+	}
+	return groups
+}
+
+/* Encode every path in the -json document just before it's written
+ * Parameters:
+ *	- report: The document.  The slices and structs it shares with the reports
+ *	  are copied rather than changed.
+ */
+func (report *jsonReport) encodePaths() {
+	dirs := make([]dirResult, len(report.Directories))
+	for i, r := range report.Directories {
+		dirs[i] = encodeResultPaths(r)
+	}
+	report.Directories = dirs
+	for _, files := range [][]fileEntry{report.LargestFiles, report.LargestDirs} {
+		for i := range files {
+			files[i].Path = encodePath(files[i].Path)
+		}
+	}
+	report.ByExtension = encodeGroupKeys(report.ByExtension)
+	report.ByMount = encodeGroupKeys(report.ByMount)
+	for i, t := range report.ByTop {
+		report.ByTop[i] = topBreakdown{Path: encodePath(t.Path), Groups: encodeGroupKeys(t.Groups)}
+	}
+	report.Empty = encodePaths(report.Empty)
+	report.SparseFiles = slices.Clone(report.SparseFiles)
+	for i := range report.SparseFiles {
+		report.SparseFiles[i].Path = encodePath(report.SparseFiles[i].Path)
+	}
+	for i := range report.Duplicates {
+		report.Duplicates[i].Paths = encodePaths(report.Duplicates[i].Paths)
+	}
+	if s := report.Stats; s != nil {
+		for _, f := range []*fileEntry{s.Smallest, s.Largest} {
+			if f != nil {
+				f.Path = encodePath(f.Path)
+			}
+		}
+	}
+	if e := report.Extremes; e != nil {
+		report.Extremes = &extremes{Oldest: encodeExtreme(e.Oldest), Largest: encodeExtreme(e.Largest)}
+	}
+	if m := report.Manifest; m != nil {
+		report.Manifest = &manifestChanges{Changed: encodePaths(m.Changed), Added: encodePaths(m.Added), Removed: encodePaths(m.Removed)}
+	}
+}
+
+/* Encode the path of a file found by -extremes
+ * Parameters:
+ *	- f: The file, or nil, which is left as it is
+ * Returns:
+ *	- *extremeFile: A copy with its path encoded, or nil
+ */
+func encodeExtreme(f *extremeFile) *extremeFile {
+	if f == nil {
+		return nil
+	}
+	c := *f
+	c.Path = encodePath(c.Path)
+	return &c
+}
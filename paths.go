@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+/* Rewrite the arguments in the form every path should be printed in, for -abs
+ * and -rel.  Every path in the output is built from an argument, so this is all
+ * it takes to change them.
+ * Parameters:
+ *  - dirs: The arguments, as given
+ *  - base: The directory to make them relative to, or "" to make them absolute
+ * Returns:
+ *  - ([]string, error): The rewritten arguments, or an error if they couldn't be
+ *    resolved.  With a base, the working directory is changed to it so that the
+ *    relative paths still lead to the same files.
+ */
+func relocatePaths(dirs []string, base string) ([]string, error) {
+	paths := make([]string, len(dirs))
+	for i, d := range dirs {
+		// Remote paths are already absolute
+		if isRemote(d) {
+			paths[i] = d
+			continue
+		}
+		abs, err := filepath.Abs(d)
+		if err != nil {
+			return nil, err
+		}
+		paths[i] = abs
+	}
+	if base == "" {
+		return paths, nil
+	}
+
+	base, err := filepath.Abs(base)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(base); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("-rel base %s is not a directory", base)
+	}
+	for i, p := range paths {
+		if isRemote(p) {
+			continue
+		}
+		if paths[i], err = filepath.Rel(base, p); err != nil {
+			return nil, err
+		}
+	}
+	return paths, os.Chdir(base)
+}
+
+/* Check that every argument can be measured before walking any of them, for
+ * -validate
+ * Parameters:
+ *  - dirs: The arguments, as given
+ * Returns:
+ *  - []string: The arguments that exist and can be read, in order.  Each one that
+ *    can't is reported on stderr.  Remote arguments are only checked once
+ *    they're connected to, so they are always kept.
+ */
+func validatePaths(dirs []string) []string {
+	var valid []string
+	for _, d := range dirs {
+		if isRemote(d) {
+			valid = append(valid, d)
+			continue
+		}
+		if err := checkPath(d); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid argument %s: %s\n", d, err)
+			continue
+		}
+		valid = append(valid, d)
+	}
+	return valid
+}
+
+/* Check that an argument exists and, if it's a directory, can be listed
+ * Parameters:
+ *  - p: The argument
+ * Returns:
+ *  - error: Why it can't be measured, or nil
+ */
+func checkPath(p string) error {
+	info, err := os.Lstat(p)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return errors.New("no such file or directory")
+	case err != nil:
+		return err
+	}
+	// Look through a symlink, since one to a directory may be followed
+	if info.Mode()&fs.ModeSymlink != 0 {
+		if target, err := os.Stat(p); err == nil {
+			info = target
+		}
+	}
+	if !info.IsDir() {
+		return nil
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrPermission) {
+		return errors.New("permission denied")
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.ReadDir(1); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
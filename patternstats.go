@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// What one -exclude or -include pattern matched
+type patternCount struct {
+	files int64 // Files matched
+	bytes int64 // The files' sizes
+	dirs  int64 // Directories matched, for -exclude, whose contents aren't walked
+}
+
+// Matches by rule, like "-exclude *.log", for -pattern-stats.  Each entry is only
+// counted under the first pattern that matched it.
+type patternCounter struct {
+	mu     sync.Mutex
+	counts map[string]*patternCount
+}
+
+// The counter for -pattern-stats, or nil if it isn't set
+var patternStats *patternCounter
+
+/* Create an empty counter
+ * Returns:
+ *  - *patternCounter: A counter with nothing matched
+ */
+func newPatternCounter() *patternCounter {
+	return &patternCounter{counts: make(map[string]*patternCount)}
+}
+
+/* Count an entry a pattern matched
+ * Parameters:
+ *  - rule: The pattern's rule, like "-exclude *.log" or "-include *.go"
+ *  - isDir: Whether the entry is a directory
+ *  - size: The size of a file, which would have counted towards the total
+ */
+func (c *patternCounter) add(rule string, isDir bool, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.counts[rule]
+	if n == nil {
+		n = &patternCount{}
+		c.counts[rule] = n
+	}
+	if isDir {
+		n.dirs++
+		return
+	}
+	n.files++
+	n.bytes += size
+}
+
+/* Print what each pattern matched on stderr, after the totals
+ */
+func (c *patternCounter) print() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// List every pattern in the order it was given, including those that matched
+	// nothing, which are the ones worth pruning
+	var rules []string
+	seen := make(map[string]bool)
+	for _, p := range excludeFlag {
+		rules = append(rules, "-exclude "+p)
+	}
+	for _, p := range includeFlag {
+		rules = append(rules, "-include "+p)
+	}
+	fmt.Fprintln(os.Stderr, "Pattern matches:")
+	for _, rule := range rules {
+		if seen[rule] {
+			continue
+		}
+		seen[rule] = true
+		n := c.counts[rule]
+		if n == nil {
+			n = &patternCount{}
+		}
+		line := fmt.Sprintf("%s: %d files, %s", rule, n.files, formatSize(n.bytes))
+		if n.dirs > 0 {
+			line += fmt.Sprintf(", %d directories (not walked)", n.dirs)
+		}
+		fmt.Fprintln(os.Stderr, line)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+)
+
+// The files -cpuprofile and -memprofile are written to, while the run is profiled
+var cpuProfile, memProfile *os.File
+
+// Held once the run is being given up on, by exitDuringRun
+var exiting sync.Mutex
+
+/* Start the CPU profile for -cpuprofile and create the file for -memprofile,
+ * so a bad path is caught before the run rather than after it
+ * Returns:
+ *	- error: An error if a file couldn't be created or profiling couldn't start
+ */
+func startProfiles() error {
+	if memprofileFlag != "" {
+		f, err := os.Create(memprofileFlag)
+		if err != nil {
+			return fmt.Errorf("-memprofile: %w", err)
+		}
+		memProfile = f
+	}
+	if cpuprofileFlag != "" {
+		f, err := os.Create(cpuprofileFlag)
+		if err == nil {
+			if err = pprof.StartCPUProfile(f); err != nil {
+				f.Close()
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("-cpuprofile: %w", err)
+		}
+		cpuProfile = f
+	}
+	return nil
+}
+
+/* Finish the CPU profile and write the heap profile, for -cpuprofile and
+ * -memprofile.  This runs once the directories are done, however the run ended,
+ * so a run cut short by Ctrl-C or -max-runtime still leaves complete profiles.
+ * Returns:
+ *	- bool: false if either profile couldn't be written
+ */
+func stopProfiles() bool {
+	ok := true
+	if cpuProfile != nil {
+		pprof.StopCPUProfile()
+		if err := cpuProfile.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing -cpuprofile file: %v\n", err)
+			ok = false
+		}
+		cpuProfile = nil
+	}
+	if memProfile != nil {
+		// Bring the statistics up to date with everything allocated so far
+		runtime.GC()
+		err := pprof.WriteHeapProfile(memProfile)
+		if closeErr := memProfile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing -memprofile file: %v\n", err)
+			ok = false
+		}
+		memProfile = nil
+	}
+	return ok
+}
+
+/* Give up on the run, for an error that makes its output worthless.  The
+ * profiles are still finished first, since a run that fails is often the one
+ * worth profiling.
+ * Parameters:
+ *	- code: The exit status
+ */
+func exitDuringRun(code int) {
+	// Whoever gets here first exits, and anyone after waits for it
+	exiting.Lock()
+	stopProfiles()
+	os.Exit(code)
+}
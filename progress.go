@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How often -progress updates its status line
+const progressInterval = 500 * time.Millisecond
+
+// Running totals across every walk, for -progress
+var progressFiles, progressBytes atomic.Int64
+
+/* Start writing a status line to stderr until the returned function is called
+ * Returns:
+ *	- func(): Stops the updates and clears the status line
+ */
+func startProgress() func() {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+
+		width := 0
+		for {
+			select {
+			case <-ticker.C:
+				line := fmt.Sprintf("%d files, %s", progressFiles.Load(), humanReadableSize(progressBytes.Load(), unitBase(), unitLabels()))
+				// Pad over any leftovers from a longer previous line
+				fmt.Fprintf(os.Stderr, "\r%-*s", width, line)
+				width = len(line)
+			case <-done:
+				if width > 0 {
+					fmt.Fprintf(os.Stderr, "\r%s\r", strings.Repeat(" ", width))
+				}
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
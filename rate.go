@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Paces the walk for -rate, or nil for no limit
+var rateTicker *time.Ticker
+
+/* Limit every walk to a number of filesystem operations per second, shared
+ * between the -jobs workers
+ * Parameters:
+ *	- perSecond: The limit, where 0 means unlimited
+ */
+func startRateLimit(perSecond int) {
+	if perSecond <= 0 {
+		return
+	}
+	// Anything above a billion per second is no limit at all
+	if interval := time.Second / time.Duration(perSecond); interval > 0 {
+		rateTicker = time.NewTicker(interval)
+	}
+}
+
+/* Wait for the next filesystem operation to be allowed under -rate
+ * Parameters:
+ *	- ctx: Cancelling this stops the wait
+ * Returns:
+ *	- error: The context's error if it was cancelled first
+ */
+func throttle(ctx context.Context) error {
+	if rateTicker == nil {
+		return nil
+	}
+	select {
+	case <-rateTicker.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
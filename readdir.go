@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+)
+
+/* Walk a tree like fs.WalkDir, but read each directory -readdir-buffer entries
+ * at a time, and with -sample only visit some of each directory's files
+ * Parameters:
+ *  - fsys: The tree to walk
+ *  - root: Where to start, relative to fsys
+ *  - fn: Called for every entry, exactly as fs.WalkDir would call it, apart
+ *    from the files -sample leaves out
+ * Returns:
+ *  - error: An error if the walk was aborted
+ */
+func walkDir(fsys fs.FS, root string, fn fs.WalkDirFunc) error {
+	if readdirBufferFlag <= 0 && sampleFlag <= 0 {
+		return fs.WalkDir(fsys, root, fn)
+	}
+	info, err := fs.Stat(fsys, root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkDirEntry(fsys, root, fs.FileInfoToDirEntry(info), fn)
+	}
+	if errors.Is(err, fs.SkipDir) || errors.Is(err, fs.SkipAll) {
+		return nil
+	}
+	return err
+}
+
+/* Walk one entry and, if it's a directory, everything beneath it
+ * Parameters:
+ *  - fsys: The tree being walked
+ *  - name: The entry's path relative to fsys
+ *  - d: The entry
+ *  - fn: Called for every entry
+ * Returns:
+ *  - error: fs.SkipDir or fs.SkipAll from fn, or an error if the walk was aborted
+ */
+func walkDirEntry(fsys fs.FS, name string, d fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(name, d, nil); err != nil || !d.IsDir() {
+		if errors.Is(err, fs.SkipDir) && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+
+	entries, err := readDirBatched(fsys, name, readdirBufferFlag)
+	if err != nil {
+		// Give fn a chance to skip the directory, then carry on with whatever was read
+		if err = fn(name, d, err); err != nil {
+			if errors.Is(err, fs.SkipDir) {
+				err = nil
+			}
+			return err
+		}
+	}
+	if sampleFlag > 0 {
+		entries = sampleEntries(entries, sampleFlag)
+	}
+	for _, e := range entries {
+		if err := walkDirEntry(fsys, path.Join(name, e.Name()), e, fn); err != nil {
+			if errors.Is(err, fs.SkipDir) {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+/* Read a directory n entries at a time
+ * Parameters:
+ *  - fsys: The tree
+ *  - name: The directory's path relative to fsys
+ *  - n: How many entries to ask for from each File.ReadDir call, or 0 or less
+ *    for all of them at once
+ * Returns:
+ *  - ([]fs.DirEntry, error): Every entry, sorted by name as fs.ReadDir sorts
+ *    them, and any error, along with the entries read before it
+ */
+func readDirBatched(fsys fs.FS, name string, n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		return fs.ReadDir(fsys, name)
+	}
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dir, ok := f.(fs.ReadDirFile)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not implemented")}
+	}
+
+	var entries []fs.DirEntry
+	for {
+		batch, err := dir.ReadDir(n)
+		entries = append(entries, batch...)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			sortEntries(entries)
+			return entries, err
+		}
+	}
+	sortEntries(entries)
+	return entries, nil
+}
+
+/* Sort directory entries by name
+ * Parameters:
+ *  - entries: The entries, sorted in place
+ */
+func sortEntries(entries []fs.DirEntry) {
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"path"
+	"strings"
+)
+
+/* Check whether an argument names a directory on a remote host
+ * Parameters:
+ *  - p: The argument, or a path built from one
+ * Returns:
+ *  - bool: true if p is an sftp:// URL
+ */
+func isRemote(p string) bool {
+	return strings.HasPrefix(p, "sftp://")
+}
+
+/* Split an sftp:// argument into where to connect and what to measure
+ * Parameters:
+ *  - p: The argument, like sftp://user@host:2222/srv/data.  A path starting with
+ *    /~/ is relative to the remote user's home directory.
+ * Returns:
+ *  - (*url.URL, string, error): The parsed URL, the remote path to walk, or an
+ *    error if the URL is malformed
+ */
+func parseRemote(p string) (*url.URL, string, error) {
+	u, err := url.Parse(p)
+	if err != nil {
+		return nil, "", err
+	}
+	if u.Hostname() == "" {
+		return nil, "", errors.New("no host in sftp:// URL")
+	}
+	remote := u.Path
+	switch {
+	case remote == "" || remote == "/~":
+		remote = "."
+	case strings.HasPrefix(remote, "/~/"):
+		remote = path.Clean(remote[len("/~/"):])
+	default:
+		remote = path.Clean(remote)
+	}
+	return u, remote, nil
+}
+
+/* Check a remote directory against -recursive, -depth, -no-recurse-into and the
+ * exclusions
+ * Parameters:
+ *  - rel: The directory's path below the argument, with "/" separators
+ * Returns:
+ *  - bool: true if the walk should descend into it
+ */
+func wantRemoteDir(rel string) bool {
+	if !recursiveFlag || (depthFlag >= 0 && strings.Count(rel, "/")+1 > depthFlag) {
+		return false
+	}
+	if isExcluded(path.Base(rel)) || noRecurseInto(path.Base(rel)) {
+		return false
+	}
+	for _, re := range excludeRegexps {
+		if re.MatchString(rel) {
+			return false
+		}
+	}
+	return true
+}
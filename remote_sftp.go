@@ -0,0 +1,161 @@
+//go:build sftp
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+/* Connect to the host in an sftp:// URL, authenticating with the local SSH agent
+ * and checking the host key against ~/.ssh/known_hosts
+ * Parameters:
+ *  - u: The parsed URL
+ * Returns:
+ *  - (*sftp.Client, func(), error): The client and a function that closes the
+ *    connection, or an error if it couldn't be made
+ */
+func dialSFTP(u *url.URL) (*sftp.Client, func(), error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, nil, errors.New("no SSH agent: SSH_AUTH_SOCK isn't set")
+	}
+	agentConn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't reach the SSH agent: %w", err)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		agentConn.Close()
+		return nil, nil, err
+	}
+	hostKeys, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		agentConn.Close()
+		return nil, nil, fmt.Errorf("couldn't read known_hosts: %w", err)
+	}
+
+	name := u.User.Username()
+	if name == "" {
+		if current, err := user.Current(); err == nil {
+			name = current.Username
+		}
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            name,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeysCallback(agent.NewClient(agentConn).Signers)},
+		HostKeyCallback: hostKeys,
+	})
+	if err != nil {
+		agentConn.Close()
+		return nil, nil, err
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		agentConn.Close()
+		return nil, nil, err
+	}
+	return client, func() {
+		client.Close()
+		conn.Close()
+		agentConn.Close()
+	}, nil
+}
+
+/* Calculate the size of a directory on a remote host, with the same recursion,
+ * exclusion and filtering flags as a local walk
+ * Parameters:
+ *  - ctx: Cancelling this stops the walk
+ *  - p: The sftp:// argument
+ * Returns:
+ *  - (dirResult, error): Total size and file count, or an error if the host
+ *    couldn't be reached or the directory walked
+ */
+func remoteSize(ctx context.Context, p string) (dirResult, error) {
+	u, root, err := parseRemote(p)
+	if err != nil {
+		return dirResult{}, err
+	}
+	client, closeConn, err := dialSFTP(u)
+	if err != nil {
+		return dirResult{}, err
+	}
+	defer closeConn()
+
+	result := dirResult{Path: p}
+	walk := client.Walk(root)
+	for walk.Step() {
+		if err := ctx.Err(); err != nil {
+			return dirResult{}, err
+		}
+		if err := walk.Err(); err != nil {
+			if errors.Is(err, fs.ErrPermission) && walk.Path() != root {
+				permissionSkips.Add(1)
+				continue
+			}
+			return dirResult{}, err
+		}
+
+		info := walk.Stat()
+		rel := strings.TrimPrefix(strings.TrimPrefix(walk.Path(), root), "/")
+		if rel == "" {
+			// A file argument has nothing to walk
+			if info.Mode().IsRegular() {
+				result.IsFile = true
+			} else {
+				continue
+			}
+		}
+		if info.IsDir() {
+			if !wantRemoteDir(rel) {
+				walk.SkipDir()
+			}
+			continue
+		}
+		if err := checkFileLimit(); err != nil {
+			if partialFlag {
+				return result, err
+			}
+			return dirResult{}, err
+		}
+		if !info.Mode().IsRegular() || (rel != "" && !wantArchiveEntry(rel)) {
+			continue
+		}
+		entry := p
+		if rel != "" {
+			entry = strings.TrimSuffix(p, "/") + "/" + rel
+		}
+		if !wantFile(entry, info) {
+			continue
+		}
+		size := fileSize(info)
+		result.Size += size
+		result.Files++
+		recordFile(entry, info, size)
+		recordDepth(strings.Count(rel, "/"), size)
+		recordTop(p, rel, size)
+		if progressFlag {
+			progressFiles.Add(1)
+			progressBytes.Add(size)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,20 @@
+//go:build !sftp
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+/* Remote arguments can't be measured without SFTP support, which needs
+ * golang.org/x/crypto and github.com/pkg/sftp and is only built in with -tags sftp
+ * Parameters:
+ *  - ctx: Unused
+ *  - p: The sftp:// argument
+ * Returns:
+ *  - (dirResult, error): Always an error
+ */
+func remoteSize(ctx context.Context, p string) (dirResult, error) {
+	return dirResult{}, errors.New("sftp:// arguments need a build with -tags sftp")
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"syscall"
+	"time"
+)
+
+// How long to wait before the first -retry, doubling for each one after that
+// up to the longest wait
+const (
+	retryBackoff  = 50 * time.Millisecond
+	maxRetryDelay = 5 * time.Second
+)
+
+/* Check whether an error is worth retrying, as network filesystems sometimes
+ * report transient failures
+ * Parameters:
+ *  - err: The error from stat
+ * Returns:
+ *  - bool: true for EINTR, ESTALE and EIO
+ */
+func isRetryable(err error) bool {
+	return errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.ESTALE) || errors.Is(err, syscall.EIO)
+}
+
+/* Work out how long to wait before a retry
+ * Parameters:
+ *  - attempt: Which retry it is, from 1
+ * Returns:
+ *  - time.Duration: retryBackoff doubled for each retry before this one, but
+ *    never more than maxRetryDelay
+ */
+func retryDelay(attempt int) time.Duration {
+	delay := retryBackoff
+	for n := 1; n < attempt && delay < maxRetryDelay; n++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+/* Stat a path, retrying transient errors up to -retry times
+ * Parameters:
+ *  - ctx: Cancelling this stops waiting to retry
+ *  - p: The path being stat'ed, for -verbose
+ *  - stat: Gets the file info
+ * Returns:
+ *  - (fs.FileInfo, error): The file info, or the last error if every attempt
+ *    failed, the error isn't retryable or ctx was cancelled first
+ */
+func statWithRetry(ctx context.Context, p string, stat func() (fs.FileInfo, error)) (fs.FileInfo, error) {
+	info, err := stat()
+	for attempt := 1; attempt <= retryFlag && err != nil && isRetryable(err); attempt++ {
+		delay := retryDelay(attempt)
+		slog.Debug("retrying stat", "path", p, "attempt", attempt, "delay", delay, "err", err)
+		if verboseFlag {
+			fmt.Fprintf(os.Stderr, "Retrying %s in %s (attempt %d of %d): %v\n", p, delay, attempt, retryFlag, err)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return info, err
+		}
+		info, err = stat()
+	}
+	return info, err
+}
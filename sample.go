@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"sync/atomic"
+)
+
+// Files listed in the directories walked, and how many of them -sample picked
+var sampleListed, sampleTaken atomic.Int64
+
+// A file picked by -sample, standing in for the files in its directory that
+// weren't
+type sampledEntry struct {
+	fs.DirEntry
+	weight float64 // How many of the directory's files it stands for, itself included
+}
+
+/* Pick which of a directory's files to look at, for -sample
+ * Parameters:
+ *	- entries: The directory's entries, sorted by name
+ *	- n: How many regular files to keep
+ * Returns:
+ *	- []fs.DirEntry: Every entry that isn't a regular file, and n of the regular
+ *	  files picked at random, in the same order.  If there are more than n, each
+ *	  picked file is a sampledEntry carrying its share of the rest.
+ */
+func sampleEntries(entries []fs.DirEntry, n int) []fs.DirEntry {
+	var files []int
+	for i, e := range entries {
+		if e.Type().IsRegular() {
+			files = append(files, i)
+		}
+	}
+	sampleListed.Add(int64(len(files)))
+	if len(files) <= n {
+		sampleTaken.Add(int64(len(files)))
+		return entries
+	}
+	sampleTaken.Add(int64(n))
+
+	rand.Shuffle(len(files), func(i, j int) { files[i], files[j] = files[j], files[i] })
+	picked := make(map[int]bool, n)
+	for _, i := range files[:n] {
+		picked[i] = true
+	}
+	weight := float64(len(files)) / float64(n)
+	kept := make([]fs.DirEntry, 0, len(entries)-len(files)+n)
+	for i, e := range entries {
+		switch {
+		case picked[i]:
+			kept = append(kept, sampledEntry{e, weight})
+		case !e.Type().IsRegular():
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+/* Find how many files an entry stands for
+ * Parameters:
+ *	- d: An entry from the walk
+ * Returns:
+ *	- float64: More than 1 for a file picked by -sample from a larger directory,
+ *	  otherwise 1
+ */
+func entryWeight(d fs.DirEntry) float64 {
+	if s, ok := d.(sampledEntry); ok {
+		return s.weight
+	}
+	return 1
+}
+
+/* Warn on stderr that the totals are estimates, if -sample left any files out
+ */
+func printSampleNote() {
+	taken, listed := sampleTaken.Load(), sampleListed.Load()
+	if taken == listed {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Estimated from %d of %d files (-sample %d); the totals can be far off where a few files are much larger than the rest of their directory\n",
+		taken, listed, sampleFlag)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Builds a JSON Schema from the structs that -json and -ndjson marshal, so the
+// two can't drift apart
+type schemaBuilder struct {
+	defs map[string]any
+}
+
+/* Describe a Go type as a JSON Schema, adding any structs it uses to the
+ * definitions
+ * Parameters:
+ *	- t: The type to describe
+ * Returns:
+ *	- map[string]any: The schema for the type
+ */
+func (b *schemaBuilder) schema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return b.schema(t.Elem())
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Struct:
+		// Times are marshalled as RFC 3339 strings
+		if t == reflect.TypeOf(time.Time{}) {
+			return map[string]any{"type": "string", "format": "date-time"}
+		}
+		// Refer to structs by name, which also copes with recursive ones like dirNode
+		ref := map[string]any{"$ref": "#/$defs/" + t.Name()}
+		if _, ok := b.defs[t.Name()]; ok {
+			return ref
+		}
+		b.defs[t.Name()] = nil
+		b.defs[t.Name()] = b.object(t)
+		return ref
+	}
+	return map[string]any{}
+}
+
+/* Describe a struct as a JSON Schema object, using its json tags
+ * Parameters:
+ *	- t: The struct type
+ * Returns:
+ *	- map[string]any: The schema, with the fields that are never omitted required
+ */
+func (b *schemaBuilder) object(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = b.schema(f.Type)
+		if !strings.Contains(","+opts+",", ",omitempty,") {
+			required = append(required, name)
+		}
+	}
+	return map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+/* Print a JSON Schema for the -json output, with the -ndjson lines as a
+ * definition, for -print-schema
+ */
+func printSchema() {
+	b := &schemaBuilder{defs: make(map[string]any)}
+	root := b.schema(reflect.TypeOf(jsonReport{}))
+	b.defs["ndjsonLine"] = map[string]any{
+		"oneOf": []any{b.schema(reflect.TypeOf(dirResult{})), b.schema(reflect.TypeOf(ndjsonTotal{}))},
+	}
+
+	doc := map[string]any{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"title":       fmt.Sprintf("hello-ford -json output, schema version %d", jsonSchemaVersion),
+		"description": "Each line of -ndjson output is described by #/$defs/ndjsonLine instead",
+		"$ref":        root["$ref"],
+		"$defs":       b.defs,
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error encoding schema: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(data))
+}
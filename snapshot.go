@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The version of the -save file format, which -load checks
+const snapshotVersion = 1
+
+// A counted file, as saved by -save so that the per-file reports can be run again
+type snapshotFile struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"` // The size it contributed to the total
+	ModTime time.Time `json:"modTime"`
+}
+
+// Everything a run measured, written by -save and read back by -load
+type snapshot struct {
+	Version     int            `json:"snapshotVersion"`
+	Options     string         `json:"options"`   // The flags that affected the results, for reference
+	Tree        bool           `json:"tree"`      // Whether the directories have their -tree outlines
+	CountDirs   bool           `json:"countDirs"` // Whether the directories have -count-dirs counts
+	Directories []dirResult    `json:"directories"`
+	Files       []snapshotFile `json:"files"`
+}
+
+// The files counted so far, when -save is set
+var savedFiles []snapshotFile
+
+// The run read by -load, or nil to measure the arguments as usual
+var loadedSnapshot *snapshot
+
+/* Write everything a run measured to a file, for -save
+ * Parameters:
+ *	- name: Path of the file
+ *	- results: Per-directory results, with overlaps already marked
+ * Returns:
+ *	- error: An error if the file couldn't be written
+ */
+func saveSnapshot(name string, results []dirResult) error {
+	s := snapshot{
+		Version:     snapshotVersion,
+		Options:     checkpointOptions(),
+		Tree:        treeFlag,
+		CountDirs:   countDirsFlag,
+		Directories: results,
+		Files:       savedFiles,
+	}
+	if s.Files == nil {
+		s.Files = []snapshotFile{}
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	// Write a new file and rename it over the old, so a failed write doesn't
+	// lose an earlier snapshot
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+/* Read a file written by -save, for -load
+ * Parameters:
+ *	- name: Path of the file
+ * Returns:
+ *	- (*snapshot, error): The saved run, or an error if it can't be read, isn't
+ *	  a snapshot, or lacks what the flags ask for
+ */
+func loadSnapshot(name string) (*snapshot, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if s.Version != snapshotVersion {
+		return nil, fmt.Errorf("%s: expected a -save file with version %d, got %d", name, snapshotVersion, s.Version)
+	}
+	if treeFlag && !s.Tree {
+		return nil, fmt.Errorf("%s was saved without -tree, so it has no subdirectories to show", name)
+	}
+	if countDirsFlag && !s.CountDirs {
+		return nil, fmt.Errorf("%s was saved without -count-dirs", name)
+	}
+	return &s, nil
+}
+
+/* Split a -merge argument into its label and file
+ * Parameters:
+ *	- arg: The argument, FILE or LABEL=FILE
+ * Returns:
+ *	- (string, string): The label, which defaults to the file's base name without
+ *	  its extension, and the file
+ */
+func mergeLabel(arg string) (string, string) {
+	if label, name, found := strings.Cut(arg, "="); found && label != "" && !strings.ContainsRune(label, filepath.Separator) {
+		return label, name
+	}
+	base := filepath.Base(arg)
+	return strings.TrimSuffix(base, filepath.Ext(base)), arg
+}
+
+/* Combine several files written by -save into one run, for -merge.  An argument
+ * path measured by more than one of them is prefixed with each one's label, as
+ * are the paths of its files and subdirectories, so they don't run together.
+ * Parameters:
+ *	- args: The files, each as FILE or LABEL=FILE
+ * Returns:
+ *	- (*snapshot, error): The combined run, or an error if a file can't be
+ *	  read, has a different -save format version, or two files share a label
+ */
+func mergeSnapshots(args []string) (*snapshot, error) {
+	labels := make([]string, len(args))
+	runs := make([]*snapshot, len(args))
+	from := make(map[string]string)
+	for i, arg := range args {
+		label, name := mergeLabel(arg)
+		if other, ok := from[label]; ok {
+			return nil, fmt.Errorf("%s and %s are both labelled %q; give them labels as LABEL=FILE", other, name, label)
+		}
+		from[label] = name
+		s, err := loadSnapshot(name)
+		if err != nil {
+			return nil, err
+		}
+		labels[i], runs[i] = label, s
+	}
+
+	// Find the paths that more than one run measured
+	measured := make(map[string]int)
+	for _, s := range runs {
+		seen := make(map[string]bool)
+		for _, p := range s.paths() {
+			if !seen[p] {
+				seen[p] = true
+				measured[p]++
+			}
+		}
+	}
+
+	merged := &snapshot{Version: snapshotVersion, Options: runs[0].Options, Tree: true, CountDirs: true, Files: []snapshotFile{}}
+	for i, s := range runs {
+		if s.Options != merged.Options {
+			fmt.Fprintf(os.Stderr, "Warning: %s was saved with different options from %s, so its sizes may not be comparable\n", from[labels[i]], from[labels[0]])
+		}
+		merged.Tree = merged.Tree && s.Tree
+		merged.CountDirs = merged.CountDirs && s.CountDirs
+
+		var shared []string
+		for _, p := range s.paths() {
+			if measured[p] > 1 {
+				shared = append(shared, p)
+			}
+		}
+		prefix := func(p string) string {
+			for _, dir := range shared {
+				if p == dir || isInside(p, dir) {
+					return labels[i] + ":" + p
+				}
+			}
+			return p
+		}
+		for _, r := range s.Directories {
+			r.Path = prefix(r.Path)
+			if r.Overlaps != "" {
+				r.Overlaps = prefix(r.Overlaps)
+			}
+			if r.Tree != nil {
+				r.Tree.relabel(prefix)
+			}
+			merged.Directories = append(merged.Directories, r)
+		}
+		for _, f := range s.Files {
+			f.Path = prefix(f.Path)
+			merged.Files = append(merged.Files, f)
+		}
+	}
+	return merged, nil
+}
+
+/* Rename every directory in a -tree outline
+ * Parameters:
+ *	- rename: Gives each directory's new path from its old one
+ */
+func (n *dirNode) relabel(rename func(string) string) {
+	n.Path = rename(n.Path)
+	for _, c := range n.Children {
+		c.relabel(rename)
+	}
+}
+
+/* Get the arguments a snapshot measured
+ * Returns:
+ *	- []string: Their paths, in the order they were given
+ */
+func (s *snapshot) paths() []string {
+	paths := make([]string, len(s.Directories))
+	for i, r := range s.Directories {
+		paths[i] = r.Path
+	}
+	return paths
+}
+
+/* Feed a snapshot to the reports as if it had just been measured
+ * Returns:
+ *	- []dirResult: The saved results, with their -tree outlines sorted and cut
+ *	  down as the flags ask
+ */
+func (s *snapshot) replay() []dirResult {
+	for _, f := range s.Files {
+		recordFile(f.Path, snapshotFileInfo{f}, f.Size)
+	}
+	results := make([]dirResult, len(s.Directories))
+	for i, r := range s.Directories {
+		switch {
+		case !treeFlag:
+			r.Tree = nil
+		case r.Tree != nil:
+			r.Tree.sortChildren(sortFlag)
+			if reportDepthFlag >= 0 {
+				r.Tree.prune(reportDepthFlag)
+			}
+		}
+		results[i] = r
+		if ndjsonFlag {
+			streamResult(r)
+		}
+	}
+	return results
+}
+
+// The file info of a saved file.  Only the name, size and modification time are
+// kept, which is all the reports -load supports look at.
+type snapshotFileInfo struct {
+	f snapshotFile
+}
+
+func (i snapshotFileInfo) Name() string { return filepath.Base(i.f.Path) }
+
+func (i snapshotFileInfo) Size() int64 { return i.f.Size }
+
+func (i snapshotFileInfo) Mode() fs.FileMode { return 0o644 }
+
+func (i snapshotFileInfo) ModTime() time.Time { return i.f.ModTime }
+
+func (i snapshotFileInfo) IsDir() bool { return false }
+
+func (i snapshotFileInfo) Sys() any { return nil }
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"sort"
+)
+
+// A file that occupies much less space on disk than its apparent size
+type sparseFile struct {
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`      // Apparent size in bytes
+	Allocated int64  `json:"allocated"` // Bytes allocated on disk
+	Gap       int64  `json:"gap"`       // Apparent bytes with no space allocated
+}
+
+// Sparse files found so far, for -sparse
+type sparseFinder struct {
+	files []sparseFile
+}
+
+// The apparent and allocated sizes of every counted file, for -sparse-summary
+type sparseSummary struct {
+	Apparent  int64 `json:"apparent"`  // Apparent bytes
+	Allocated int64 `json:"allocated"` // Bytes allocated on disk
+	// Apparent bytes less allocated bytes.  Rounding files up to whole blocks
+	// takes space too, so this is negative when sparseness saves less than that.
+	Saved        int64   `json:"saved"`
+	SavedPercent float64 `json:"savedPercent"` // Saved as a percentage of Apparent
+}
+
+/* Check whether a file is sparse, and remember it if so
+ * Parameters:
+ *	- p: The file's path
+ *	- info: File info for the file
+ */
+func (s *sparseFinder) add(p string, info fs.FileInfo) {
+	allocated, ok := allocatedSize(info)
+	// Only count files with less than half their apparent size allocated, so that
+	// the rounding up to whole blocks doesn't matter
+	if !ok || allocated >= info.Size()/2 {
+		return
+	}
+	s.files = append(s.files, sparseFile{Path: p, Size: info.Size(), Allocated: allocated, Gap: info.Size() - allocated})
+}
+
+/* Add a counted file to the totals
+ * Parameters:
+ *	- info: File info for the file.  Files whose allocated size can't be found,
+ *	  such as those inside archives, are left out of both totals.
+ */
+func (s *sparseSummary) add(info fs.FileInfo) {
+	allocated, ok := allocatedSize(info)
+	if !ok {
+		return
+	}
+	s.Apparent += info.Size()
+	s.Allocated += allocated
+}
+
+/* Get the totals with the savings worked out
+ * Returns:
+ *	- sparseSummary: A copy of the totals with Saved and SavedPercent filled in
+ */
+func (s *sparseSummary) summary() sparseSummary {
+	sum := *s
+	sum.Saved = sum.Apparent - sum.Allocated
+	if sum.Apparent > 0 {
+		sum.SavedPercent = float64(sum.Saved) / float64(sum.Apparent) * 100
+	}
+	return sum
+}
+
+/* Get the sparse files found
+ * Returns:
+ *	- []sparseFile: The files, with the most unallocated space first
+ */
+func (s *sparseFinder) sorted() []sparseFile {
+	sort.Slice(s.files, func(i, j int) bool {
+		a, b := s.files[i], s.files[j]
+		if a.Gap != b.Gap {
+			return a.Gap > b.Gap
+		}
+		return a.Path < b.Path
+	})
+	return s.files
+}
+
+/* Print the sparse files after the totals
+ * Parameters:
+ *	- files: The files, in the order to print them
+ */
+func printSparse(files []sparseFile) {
+	printHeading("Sparse files:")
+	var gap int64
+	for _, f := range files {
+		printRecord(fmt.Sprintf("%s: %s apparent, %s allocated (%s unallocated)", f.Path, formatSize(f.Size), formatSize(f.Allocated), formatSize(f.Gap)))
+		gap += f.Gap
+	}
+	printRecord("Unallocated: " + formatSize(gap))
+}
+
+/* Print the apparent and allocated totals after the totals
+ * Parameters:
+ *	- s: The totals, with the savings worked out
+ */
+func printSparseSummary(s sparseSummary) {
+	printHeading("Sparse summary:")
+	printRecord("Apparent: " + formatSize(s.Apparent))
+	printRecord("Allocated: " + formatSize(s.Allocated))
+	saved := formatSize(s.Saved)
+	if s.Saved < 0 {
+		saved = "-" + formatSize(-s.Saved)
+	}
+	printRecord(fmt.Sprintf("Saved: %s (%.1f%%)", saved, s.SavedPercent))
+}
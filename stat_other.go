@@ -0,0 +1,49 @@
+//go:build !unix
+
+package main
+
+import "io/fs"
+
+// This platform doesn't expose block counts, device IDs or owners, so -disk-usage,
+// -one-file-system and -by-owner are no-ops
+const sysStatSupported = false
+
+/* Hard links can't be identified on this platform, so every file is counted
+ * Parameters:
+ *  - info: File info from the walk
+ * Returns:
+ *  - (inodeKey, bool): Always false
+ */
+func hardLinkKey(info fs.FileInfo) (inodeKey, bool) {
+	return inodeKey{}, false
+}
+
+/* Allocated sizes aren't available on this platform
+ * Parameters:
+ *  - info: File info from the walk
+ * Returns:
+ *  - (int64, bool): Always false
+ */
+func allocatedSize(info fs.FileInfo) (int64, bool) {
+	return 0, false
+}
+
+/* Device IDs aren't available on this platform
+ * Parameters:
+ *  - info: File info from the walk
+ * Returns:
+ *  - (uint64, bool): Always false
+ */
+func deviceID(info fs.FileInfo) (uint64, bool) {
+	return 0, false
+}
+
+/* File owners aren't available on this platform
+ * Parameters:
+ *  - info: File info from the walk
+ * Returns:
+ *  - (uint32, bool): Always false
+ */
+func fileOwner(info fs.FileInfo) (uint32, bool) {
+	return 0, false
+}
@@ -0,0 +1,71 @@
+//go:build unix
+
+package main
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// Block counts, device IDs and owners are available from syscall.Stat_t on this platform
+const sysStatSupported = true
+
+/* Get the device and inode of a file that has more than one hard link
+ * Parameters:
+ *  - info: File info from the walk
+ * Returns:
+ *  - (inodeKey, bool): The file's identity, and false if it has a single link or
+ *    the platform stat information isn't available
+ */
+func hardLinkKey(info fs.FileInfo) (inodeKey, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || st.Nlink < 2 {
+		return inodeKey{}, false
+	}
+	return inodeKey{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
+
+/* Get the space a file actually occupies on disk
+ * Parameters:
+ *  - info: File info from the walk
+ * Returns:
+ *  - (int64, bool): Allocated bytes (blocks × 512), and false if the platform stat
+ *    information isn't available
+ */
+func allocatedSize(info fs.FileInfo) (int64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return int64(st.Blocks) * 512, true
+}
+
+/* Get the ID of the device a file lives on
+ * Parameters:
+ *  - info: File info from the walk
+ * Returns:
+ *  - (uint64, bool): The device ID, and false if the platform stat information
+ *    isn't available
+ */
+func deviceID(info fs.FileInfo) (uint64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Dev), true
+}
+
+/* Get the user ID that owns a file
+ * Parameters:
+ *  - info: File info from the walk
+ * Returns:
+ *  - (uint32, bool): The owner's UID, and false if the platform stat information
+ *    isn't available
+ */
+func fileOwner(info fs.FileInfo) (uint32, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return st.Uid, true
+}
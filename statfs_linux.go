@@ -0,0 +1,23 @@
+//go:build linux
+
+package main
+
+import "syscall"
+
+// Filesystem usage can be read with statfs on this platform
+const statfsSupported = true
+
+/* Get the space used on the filesystem a path is on
+ * Parameters:
+ *  - p: Any path on the filesystem
+ * Returns:
+ *  - (int64, error): Bytes in use, not counting blocks reserved for root, or an
+ *    error if the filesystem couldn't be queried
+ */
+func usedSpace(p string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(p, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Blocks-st.Bfree) * int64(st.Bsize), nil
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// There's no portable statfs on this platform, so -check is a no-op
+const statfsSupported = false
+
+/* Filesystem usage isn't available on this platform
+ * Parameters:
+ *  - p: Any path on the filesystem
+ * Returns:
+ *  - (int64, error): Always an error
+ */
+func usedSpace(p string) (int64, error) {
+	return 0, errors.New("filesystem usage isn't available on this platform")
+}
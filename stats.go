@@ -0,0 +1,209 @@
+package main
+
+import "sort"
+
+// Collects every counted file's size for -stats.  This costs 8 bytes per file,
+// which is needed for an exact median; with -low-memory the median is estimated
+// in constant space instead.
+type sizeStats struct {
+	sizes    []int64
+	approx   *p2Median // Used instead of sizes with -low-memory
+	files    int64
+	total    int64
+	smallest fileEntry
+	largest  fileEntry
+}
+
+// The distribution of file sizes reported by -stats
+type statsSummary struct {
+	Files    int64      `json:"files"`
+	Mean     float64    `json:"mean"`
+	Median   int64      `json:"median"`
+	Smallest *fileEntry `json:"smallest,omitempty"`
+	Largest  *fileEntry `json:"largest,omitempty"`
+
+	// The median was estimated with -low-memory rather than computed exactly
+	Approximate bool `json:"approximateMedian,omitempty"`
+}
+
+/* Create an empty set of statistics
+ * Parameters:
+ *	- approximate: Whether to estimate the median rather than keep every size
+ * Returns:
+ *	- *sizeStats: Statistics with no files
+ */
+func newSizeStats(approximate bool) *sizeStats {
+	s := &sizeStats{}
+	if approximate {
+		s.approx = &p2Median{}
+	}
+	return s
+}
+
+/* Record a counted file
+ * Parameters:
+ *	- p: Path of the file
+ *	- size: The size the file contributed to the total
+ */
+func (s *sizeStats) add(p string, size int64) {
+	e := fileEntry{Path: p, Size: size}
+	if s.files == 0 || smallerEntry(s.largest, e) {
+		s.largest = e
+	}
+	// Break ties on the path, as for the largest file, so runs are repeatable
+	if s.files == 0 || size < s.smallest.Size || (size == s.smallest.Size && p < s.smallest.Path) {
+		s.smallest = e
+	}
+	if s.approx != nil {
+		s.approx.add(float64(size))
+	} else {
+		s.sizes = append(s.sizes, size)
+	}
+	s.files++
+	s.total += size
+}
+
+/* Summarize the recorded sizes
+ * Returns:
+ *	- statsSummary: The file count, mean, median, smallest and largest file
+ */
+func (s *sizeStats) summary() statsSummary {
+	if s.files == 0 {
+		return statsSummary{}
+	}
+
+	var median int64
+	if s.approx != nil {
+		median = s.approx.median()
+	} else {
+		median = exactMedian(s.sizes)
+	}
+
+	smallest, largest := s.smallest, s.largest
+	return statsSummary{
+		Files:       s.files,
+		Mean:        float64(s.total) / float64(s.files),
+		Median:      median,
+		Smallest:    &smallest,
+		Largest:     &largest,
+		Approximate: s.approx != nil,
+	}
+}
+
+/* Find the median of some sizes
+ * Parameters:
+ *	- sizes: The sizes, at least one, in any order
+ * Returns:
+ *	- int64: The middle size, or the mean of the two middle sizes if there's an
+ *	  even number of them
+ */
+func exactMedian(sizes []int64) int64 {
+	n := len(sizes)
+	sorted := append([]int64(nil), sizes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	if n%2 == 0 {
+		return (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+	return sorted[n/2]
+}
+
+// Estimates the median of a stream in constant space with the P² algorithm (Jain
+// and Chlamtac, 1985).  Five markers track the minimum, the quartiles, the median
+// and the maximum, and are nudged towards where they should be as each value
+// arrives, fitting a parabola through their neighbours.
+type p2Median struct {
+	n       int64
+	heights [5]float64
+	pos     [5]float64 // Actual marker positions, counting from 1
+	want    [5]float64 // Desired marker positions
+	first   []int64    // The first five values, until the markers are set up
+}
+
+// How far each desired marker position moves with every value, for the median
+var p2Increments = [5]float64{0, 0.25, 0.5, 0.75, 1}
+
+/* Add a value to the estimate
+ * Parameters:
+ *	- x: The value
+ */
+func (m *p2Median) add(x float64) {
+	m.n++
+	if m.n <= 5 {
+		m.first = append(m.first, int64(x))
+		if m.n == 5 {
+			sorted := append([]int64(nil), m.first...)
+			sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+			for i := range m.heights {
+				m.heights[i] = float64(sorted[i])
+				m.pos[i] = float64(i + 1)
+			}
+			m.want = [5]float64{1, 2, 3, 4, 5}
+		}
+		return
+	}
+
+	// Find the cell x falls in, stretching the ends to fit it
+	var k int
+	switch {
+	case x < m.heights[0]:
+		m.heights[0] = x
+		k = 0
+	case x >= m.heights[4]:
+		m.heights[4] = x
+		k = 3
+	default:
+		for k = 0; k < 3 && x >= m.heights[k+1]; k++ {
+		}
+	}
+	for i := k + 1; i < 5; i++ {
+		m.pos[i]++
+	}
+	for i := range m.want {
+		m.want[i] += p2Increments[i]
+	}
+
+	// Move the middle markers that are more than one position out of place
+	for i := 1; i < 4; i++ {
+		d := m.want[i] - m.pos[i]
+		if (d >= 1 && m.pos[i+1]-m.pos[i] > 1) || (d <= -1 && m.pos[i-1]-m.pos[i] < -1) {
+			s := 1.0
+			if d < 0 {
+				s = -1
+			}
+			h := m.parabolic(i, s)
+			if m.heights[i-1] < h && h < m.heights[i+1] {
+				m.heights[i] = h
+			} else {
+				// The parabola overshot a neighbour, so interpolate linearly instead
+				j := i + int(s)
+				m.heights[i] += s * (m.heights[j] - m.heights[i]) / (m.pos[j] - m.pos[i])
+			}
+			m.pos[i] += s
+		}
+	}
+}
+
+/* Predict a marker's new height from its neighbours
+ * Parameters:
+ *	- i: The marker, 1 to 3
+ *	- s: The direction it is moving, 1 or -1
+ * Returns:
+ *	- float64: The height on the parabola through the marker and its neighbours
+ */
+func (m *p2Median) parabolic(i int, s float64) float64 {
+	q, n := m.heights, m.pos
+	return q[i] + s/(n[i+1]-n[i-1])*
+		((n[i]-n[i-1]+s)*(q[i+1]-q[i])/(n[i+1]-n[i])+
+			(n[i+1]-n[i]-s)*(q[i]-q[i-1])/(n[i]-n[i-1]))
+}
+
+/* Get the estimated median
+ * Returns:
+ *	- int64: The estimate, which is exact for up to five values
+ */
+func (m *p2Median) median() int64 {
+	if m.n < 5 {
+		return exactMedian(m.first)
+	}
+	return int64(m.heights[2] + 0.5)
+}
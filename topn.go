@@ -0,0 +1,109 @@
+package main
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// A single file and the size it contributes to the total
+type fileEntry struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+/* Order files for eviction from the largest-files heap
+ * Parameters:
+ *	- a, b: The files to compare
+ * Returns:
+ *	- bool: true if a should be evicted before b.  Among files of equal size, the
+ *	  one with the later path goes first.
+ */
+func smallerEntry(a, b fileEntry) bool {
+	if a.Size != b.Size {
+		return a.Size < b.Size
+	}
+	return a.Path > b.Path
+}
+
+// A min-heap of files ordered by size, so the smallest is always at the root
+type fileHeap []fileEntry
+
+func (h fileHeap) Len() int { return len(h) }
+
+func (h fileHeap) Less(i, j int) bool { return smallerEntry(h[i], h[j]) }
+
+func (h fileHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *fileHeap) Push(x any) { *h = append(*h, x.(fileEntry)) }
+
+func (h *fileHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// Keeps the N largest files seen, using memory proportional to N rather than the
+// number of files walked
+type topFiles struct {
+	n int
+	h fileHeap
+}
+
+/* Create a tracker for the largest files
+ * Parameters:
+ *	- n: How many files to keep
+ * Returns:
+ *	- *topFiles: An empty tracker
+ */
+func newTopFiles(n int) *topFiles {
+	return &topFiles{n: n, h: make(fileHeap, 0, n)}
+}
+
+/* Offer a file to the tracker, evicting the smallest kept file if it's full
+ * Parameters:
+ *	- e: The file to consider
+ */
+func (t *topFiles) add(e fileEntry) {
+	if len(t.h) < t.n {
+		heap.Push(&t.h, e)
+		return
+	}
+	if !smallerEntry(t.h[0], e) {
+		return
+	}
+	t.h[0] = e
+	heap.Fix(&t.h, 0)
+}
+
+/* Get the kept files, largest first
+ * Returns:
+ *	- []fileEntry: The files, sorted by size descending and then by path
+ */
+func (t *topFiles) sorted() []fileEntry {
+	files := append([]fileEntry(nil), t.h...)
+	sort.Slice(files, func(i, j int) bool {
+		return smallerEntry(files[j], files[i])
+	})
+	return files
+}
+
+/* Offer every subdirectory of an argument to the -top-dirs tracker
+ * Parameters:
+ *	- root: The argument's tree, after its totals have been rolled up.  The
+ *	  argument itself isn't offered, since it has its own line already.
+ */
+func offerLargestDirs(root *dirNode) {
+	reportMu.Lock()
+	defer reportMu.Unlock()
+	var offer func(n *dirNode)
+	offer = func(n *dirNode) {
+		for _, c := range n.Children {
+			if c.Size > 0 || !excludeEmptyFlag {
+				largestDirs.add(fileEntry{Path: c.Path, Size: c.Size})
+			}
+			offer(c)
+		}
+	}
+	offer(root)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A directory and everything below it, for -tree
+type dirNode struct {
+	Path     string     `json:"path"`
+	Size     int64      `json:"size"`              // Bytes in this directory and all of its descendants
+	Files    int64      `json:"files"`             // Regular files in this directory and all of its descendants
+	Dirs     int64      `json:"dirs,omitempty"`    // Directories walked, this one included, with -count-dirs
+	Entries  int64      `json:"entries,omitempty"` // Entries of every kind walked, this one included, with -count-dirs
+	Children []*dirNode `json:"children,omitempty"`
+}
+
+/* Add each directory's descendants to its own totals, once the walk is done
+ * Parameters:
+ *	- n: The root of the (sub)tree, holding only its own files' totals so far
+ */
+func (n *dirNode) rollUp() {
+	for _, c := range n.Children {
+		c.rollUp()
+		n.Size += c.Size
+		n.Files += c.Files
+		n.Dirs += c.Dirs
+		n.Entries += c.Entries
+	}
+}
+
+/* Sort every directory's children, honouring -sort
+ * Parameters:
+ *	- order: The -sort order, or "" to sort by name
+ */
+func (n *dirNode) sortChildren(order string) {
+	sort.Slice(n.Children, func(i, j int) bool {
+		a, b := n.Children[i], n.Children[j]
+		return sortsBefore(order, a.Path, a.Size, b.Path, b.Size)
+	})
+	for _, c := range n.Children {
+		c.sortChildren(order)
+	}
+}
+
+/* Drop the empty directories from the tree, for -exclude-empty
+ * Parameters:
+ *	- n: The root of the (sub)tree, after its totals have been rolled up
+ */
+func (n *dirNode) dropEmpty() {
+	kept := n.Children[:0]
+	for _, c := range n.Children {
+		if c.Size > 0 {
+			c.dropEmpty()
+			kept = append(kept, c)
+		}
+	}
+	n.Children = kept
+}
+
+/* Drop the directories below a certain depth from the tree.  Their sizes have
+ * already been rolled up into their ancestors, so the subtotals still count them.
+ * Parameters:
+ *	- depth: How many levels of descendants to keep, 0 for none
+ */
+func (n *dirNode) prune(depth int) {
+	if depth == 0 {
+		n.Children = nil
+		return
+	}
+	for _, c := range n.Children {
+		c.prune(depth - 1)
+	}
+}
+
+/* Build the label a -tree line is printed under
+ * Parameters:
+ *	- p: The directory's path
+ *	- depth: How far below the argument it is, 0 for the argument itself
+ * Returns:
+ *	- string: The full path for an argument, or its indented base name below one
+ */
+func treeLabel(p string, depth int) string {
+	if depth == 0 {
+		return p
+	}
+	return strings.Repeat("  ", depth) + filepath.Base(p)
+}
+
+/* Print an argument's -tree outline
+ * Parameters:
+ *	- r: The argument's result.  Its own line is printed from the result rather
+ *	  than the tree, so it keeps what only the argument has, such as the
+ *	  -dereference-count size and the -partial and -sample markers.
+ *	- total: The cumulative size of all arguments, for -percent
+ */
+func printTree(r dirResult, total int64) {
+	if withinThreshold(r.Size) {
+		printRecord(directoryLine(r, total))
+	}
+	for _, c := range r.Tree.Children {
+		printSubtree(c, 1, total)
+	}
+}
+
+/* Print a directory below an argument and its descendants as an indented outline
+ * Parameters:
+ *	- n: The directory to print
+ *	- depth: How far below the argument n is
+ *	- total: The cumulative size of all arguments, for -percent
+ */
+func printSubtree(n *dirNode, depth int, total int64) {
+	if withinThreshold(n.Size) {
+		line := dirResult{Path: treeLabel(n.Path, depth), Size: n.Size, Files: n.Files, Dirs: n.Dirs, Entries: n.Entries}
+		printRecord(directoryLine(line, total))
+	}
+	for _, c := range n.Children {
+		printSubtree(c, depth+1, total)
+	}
+}
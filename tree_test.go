@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/* Check every node of a -tree outline against measuring its directory on its
+ * own, as an argument
+ * Parameters:
+ *	- t: The test
+ *	- n: The root of the (sub)tree
+ */
+func checkTreeCounts(t *testing.T, n *dirNode) {
+	t.Helper()
+	w, err := newWalker(context.Background(), n.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.quiet = true
+	if err := w.walk(os.DirFS(n.Path), w.root); err != nil {
+		t.Fatal(err)
+	}
+	if n.Size != w.size || n.Files != w.files || n.Dirs != w.dirs || n.Entries != w.entries {
+		t.Errorf("%s in the tree = %d bytes, %d files, %d dirs, %d entries, want %d, %d, %d, %d",
+			n.Path, n.Size, n.Files, n.Dirs, n.Entries, w.size, w.files, w.dirs, w.entries)
+	}
+	for _, c := range n.Children {
+		checkTreeCounts(t, c)
+	}
+}
+
+func TestTreeCountsDirs(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"a.txt":         "aaaa",
+		"x/b.txt":       "bb",
+		"x/y/c.txt":     "c",
+		"x/y/z/d.txt":   "dddd",
+		"x/skip/e.log":  "eeeeee",
+		"w/f.txt":       "ff",
+		"w/g.txt":       "",
+		"v/.hidden/h.t": "hhh",
+	})
+	if err := os.Mkdir(filepath.Join(dir, "empty"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"-recursive", "-tree", "-count-dirs"},
+		{"-recursive", "-tree", "-count-dirs", "-exclude=*.log", "-exclude-hidden"},
+		{"-recursive", "-tree", "-count-dirs", "-no-recurse-into=y"},
+	} {
+		t.Run(strings.Join(args, " "), func(t *testing.T) {
+			setFlags(t, args...)
+			result, err := measurePath(context.Background(), dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Tree.Dirs != result.Dirs || result.Tree.Entries != result.Entries {
+				t.Errorf("tree root = %d dirs, %d entries, want the argument's %d, %d",
+					result.Tree.Dirs, result.Tree.Entries, result.Dirs, result.Entries)
+			}
+			checkTreeCounts(t, result.Tree)
+		})
+	}
+}
+
+func TestTreeLines(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "aaaa", "x/b.txt": "bb", "x/y/c.txt": "c"})
+	setFlags(t, "-children", "-recursive", "-tree", "-report-depth=1", "-count-dirs", "-dereference-count")
+	result, err := measurePath(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	output = &out
+	printTree(result, result.Size)
+	want := dir + ": 7 bytes (3 dirs, 6 entries) (7 bytes following symlinks)\n" +
+		"  x: 3 bytes (2 dirs, 4 entries)\n"
+	if out.String() != want {
+		t.Errorf("printTree printed\n%s\nwant\n%s", out.String(), want)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// There's no stty to switch the terminal to raw mode, so -interactive is a no-op
+const rawModeSupported = false
+
+/* Raw mode isn't available on this platform
+ * Returns:
+ *  - (func(), error): Always an error
+ */
+func enterRawMode() (func(), error) {
+	return nil, errors.New("raw terminal mode isn't available on this platform")
+}
+
+/* The terminal size can't be read on this platform
+ * Returns:
+ *  - (int, int): 24 rows by 80 columns
+ */
+func terminalSize() (int, int) {
+	return 24, 80
+}
@@ -0,0 +1,59 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// The terminal can be put into raw mode with stty on this platform
+const rawModeSupported = true
+
+/* Run stty on the terminal connected to stdin
+ * Parameters:
+ *  - args: The arguments to stty
+ * Returns:
+ *  - (string, error): What stty printed, or an error if it failed
+ */
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+/* Put the terminal into raw mode, so keys are read as they're pressed and not
+ * echoed, for -interactive
+ * Returns:
+ *  - (func(), error): A function that restores the previous mode, or an error if
+ *    the mode couldn't be changed
+ */
+func enterRawMode() (func(), error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the terminal mode: %w", err)
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return nil, fmt.Errorf("couldn't switch the terminal to raw mode: %w", err)
+	}
+	return func() { stty(saved) }, nil
+}
+
+/* Get the size of the terminal connected to stdin
+ * Returns:
+ *  - (int, int): The number of rows and columns, or 24 by 80 if it can't be read
+ */
+func terminalSize() (int, int) {
+	out, err := stty("size")
+	if err != nil {
+		return 24, 80
+	}
+	var rows, cols int
+	if _, err := fmt.Sscan(out, &rows, &cols); err != nil || rows <= 0 || cols <= 0 {
+		return 24, 80
+	}
+	return rows, cols
+}
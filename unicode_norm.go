@@ -0,0 +1,18 @@
+//go:build norm
+
+package main
+
+import "golang.org/x/text/unicode/norm"
+
+// NFC normalization is built in, so -normalize-unicode can be used
+const unicodeNormSupported = true
+
+/* Normalize a name to Unicode NFC, for -normalize-unicode
+ * Parameters:
+ *  - s: The name or pattern
+ * Returns:
+ *  - string: s in NFC, with any decomposed characters composed
+ */
+func nfc(s string) string {
+	return norm.NFC.String(s)
+}
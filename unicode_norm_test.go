@@ -0,0 +1,27 @@
+//go:build norm
+
+package main
+
+import "testing"
+
+func TestFirstMatchNormalizesUnicode(t *testing.T) {
+	// \u0301 is a combining acute accent, decomposed as macOS stores names
+	for _, tc := range []struct {
+		flags   []string
+		pattern string
+		name    string
+		match   bool
+	}{
+		{[]string{"-normalize-unicode"}, "café*", "cafe\u0301.txt", true},
+		{[]string{"-normalize-unicode"}, "cafe\u0301*", "café.txt", true},
+		{[]string{"-normalize-unicode", "-ignore-case"}, "CAFÉ*", "cafe\u0301.txt", true},
+		{[]string{"-normalize-unicode", "-ignore-case"}, "cafe\u0301*", "CAFÉ.TXT", true},
+		{[]string{"-ignore-case"}, "CAFÉ*", "cafe\u0301.txt", false},
+		{nil, "café*", "cafe\u0301.txt", false},
+	} {
+		setFlags(t, tc.flags...)
+		if got := firstMatch([]string{tc.pattern}, tc.name) != ""; got != tc.match {
+			t.Errorf("%v: %q matching %q = %v, want %v", tc.flags, tc.pattern, tc.name, got, tc.match)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !norm
+
+package main
+
+// NFC normalization needs golang.org/x/text and is only built in with -tags norm
+const unicodeNormSupported = false
+
+/* Names can't be normalized without golang.org/x/text
+ * Parameters:
+ *  - s: The name or pattern
+ * Returns:
+ *  - string: s unchanged
+ */
+func nfc(s string) string {
+	return s
+}
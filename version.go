@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// Build information, stamped by the release process with
+//
+//	go build -ldflags "-X main.version=1.2.3 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+/* Print the version, commit and build date for -version
+ */
+func printVersion() {
+	rev, date := commit, buildDate
+	// A plain "go build" from a checkout still records the commit it was built from
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && rev == "unknown":
+				rev = s.Value
+			case s.Key == "vcs.time" && date == "unknown":
+				date = s.Value
+			}
+		}
+	}
+	fmt.Printf("hello-ford %s (commit %s, built %s)\n", version, rev, date)
+}
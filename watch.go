@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Sizes from the previous -watch scan, by the path printed for them, or nil
+// before the second scan
+var previousScan map[string]int64
+
+/* Remember this scan's sizes so the next one can show how they changed
+ * Parameters:
+ *	- results: Per-directory results
+ *	- total: The cumulative totals, under the label they're printed with
+ */
+func rememberScan(results []dirResult, total dirResult) {
+	sizes := map[string]int64{total.Path: total.Size}
+	for _, r := range results {
+		if r.Error == "" {
+			sizes[r.Path] = r.Size
+		}
+	}
+	previousScan = sizes
+}
+
+/* Format how much a directory has grown since the previous -watch scan
+ * Parameters:
+ *	- path: The path printed for the directory
+ *	- size: Its size in this scan
+ * Returns:
+ *	- string: The change, like " (+1.5 KB since last scan)", or "" if there was
+ *	  no previous scan of it
+ */
+func formatGrowth(path string, size int64) string {
+	old, ok := previousScan[path]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf(" (%s since last scan)", formatDelta(size-old))
+}
+
+/* Measure the directories again and again, for -watch
+ * Parameters:
+ *	- ctx: Cancelling this stops the current scan, which is still printed, and
+ *	  ends the loop
+ *	- dirs: Paths to the directories
+ *	- interval: How long to wait between scans
+ * Returns:
+ *	- (bool, bool): false if the last scan had any errors, and true if it was cut
+ *	  short by the interruption rather than finishing
+ */
+func watch(ctx context.Context, dirs []string, interval time.Duration) (bool, bool) {
+	// Only clear the screen when it is one, so redirected output keeps every scan
+	f, isFile := output.(*os.File)
+	clear := isFile && isTerminal(f)
+	for {
+		if clear {
+			fmt.Fprint(output, "\x1b[H\x1b[2J")
+		}
+		printRecord(fmt.Sprintf("Every %s, scanned at %s", interval, time.Now().Format("15:04:05")))
+		ok := processDirectories(ctx, dirs)
+		if ctx.Err() != nil {
+			return ok, true
+		}
+		select {
+		case <-ctx.Done():
+			return ok, false
+		case <-time.After(interval):
+		}
+		resetRun()
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A change reported in a watched directory
+type dirChange struct {
+	dir      string // The watched directory
+	name     string // The entry in it that changed
+	isDir    bool   // The entry is a directory
+	overflow bool   // Changes were lost, so everything has to be walked again
+}
+
+// How long to let changes settle before measuring again, so a burst of writes
+// costs one update rather than one per write
+const notifySettle = 200 * time.Millisecond
+
+// An argument being watched with -watch-fsnotify
+type watchedArg struct {
+	path string              // As given
+	root string              // Cleaned, as the paths below it are spelled
+	own  map[string]*dirNode // Each directory walked, with the totals of its own files only
+	err  error               // Why the argument couldn't be measured, if it couldn't
+}
+
+/* Walk a directory of an argument, noting the totals of each directory's own
+ * files
+ * Parameters:
+ *  - ctx: Cancelling this aborts the walk
+ *  - n: The notifier, which is told about each directory walked unless shallow
+ *  - start: The directory to walk, the argument itself or one below it
+ *  - shallow: Only measure start's own files, not its subdirectories
+ * Returns:
+ *  - error: An error if the walk failed
+ */
+func (a *watchedArg) walkFrom(ctx context.Context, n *dirNotifier, start string, shallow bool) error {
+	w, err := newWalker(ctx, a.path)
+	if err != nil {
+		return err
+	}
+	w.quiet = true
+	w.nodes = make(map[string]*dirNode)
+	if shallow {
+		w.only = start
+	} else {
+		// Watch each directory before it's read, so nothing created while it is
+		// being walked is missed
+		w.entered = n.watch
+	}
+	// A walk from the argument would have loaded the .gitignore files on the way
+	// down to start
+	if w.ignore != nil && start != w.root {
+		var above []string
+		for dir := filepath.Dir(start); ; dir = filepath.Dir(dir) {
+			above = append(above, dir)
+			if dir == w.root || dir == filepath.Dir(dir) {
+				break
+			}
+		}
+		for i := len(above) - 1; i >= 0; i-- {
+			if err := w.ignore.load(w.absPath(above[i])); err != nil {
+				return err
+			}
+		}
+	}
+	if err := w.walk(os.DirFS(start), start); err != nil {
+		return err
+	}
+	for p, node := range w.nodes {
+		a.own[p] = node
+	}
+	return nil
+}
+
+/* Walk the whole argument, on startup or after changes were lost
+ * Parameters:
+ *  - ctx: Cancelling this aborts the walk
+ *  - n: The notifier to watch every directory walked with
+ */
+func (a *watchedArg) scan(ctx context.Context, n *dirNotifier) {
+	n.unwatch(a.root)
+	a.own = make(map[string]*dirNode)
+	a.err = a.walkFrom(ctx, n, a.root, false)
+}
+
+/* Measure a directory's own files again after a file in it changed
+ * Parameters:
+ *  - ctx: Cancelling this aborts the walk
+ *  - n: The notifier
+ *  - dir: The directory
+ */
+func (a *watchedArg) rescanDir(ctx context.Context, n *dirNotifier, dir string) {
+	delete(a.own, dir)
+	// A directory that's gone is dropped along with its subdirectories when
+	// its parent's change is handled
+	if err := a.walkFrom(ctx, n, dir, true); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		a.err = err
+	}
+}
+
+/* Walk a subdirectory again after it was created, removed or moved, dropping
+ * whatever was below it before
+ * Parameters:
+ *  - ctx: Cancelling this aborts the walk
+ *  - n: The notifier
+ *  - dir: The subdirectory
+ */
+func (a *watchedArg) rescanTree(ctx context.Context, n *dirNotifier, dir string) {
+	n.unwatch(dir)
+	for p := range a.own {
+		if p == dir || isInside(p, dir) {
+			delete(a.own, p)
+		}
+	}
+	// The walk takes the name from its start, which is just "." here
+	if dir != a.root && noRecurseInto(filepath.Base(dir)) {
+		return
+	}
+	if err := a.walkFrom(ctx, n, dir, false); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		a.err = err
+	}
+}
+
+/* Total the argument from its directories' own totals
+ * Returns:
+ *  - dirResult: The argument's result, or its error
+ */
+func (a *watchedArg) result() dirResult {
+	if outOfWatches(a.err) {
+		return dirResult{Path: a.path, Error: a.err.Error() + " (raise fs.inotify.max_user_watches to watch more directories)"}
+	}
+	if a.err != nil {
+		return dirResult{Path: a.path, Error: a.err.Error()}
+	}
+	result := dirResult{Path: a.path}
+	for _, node := range a.own {
+		result.Size += node.Size
+		result.Files += node.Files
+	}
+	return result
+}
+
+/* Wait for changes, then for them to settle
+ * Parameters:
+ *  - ctx: Cancelling this stops the wait
+ *  - changes: The notifier's batches of changes, closed if it fails
+ * Returns:
+ *  - []dirChange: Every change seen, or nil if ctx was cancelled or the
+ *    notifier failed
+ */
+func waitForChanges(ctx context.Context, changes <-chan []dirChange) []dirChange {
+	var seen []dirChange
+	var settled <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case batch, ok := <-changes:
+			if !ok {
+				return nil
+			}
+			seen = append(seen, batch...)
+			if settled == nil {
+				settled = time.After(notifySettle)
+			}
+		case <-settled:
+			return seen
+		}
+	}
+}
+
+/* Measure the directories, then measure again whatever changes, for
+ * -watch-fsnotify.  Only the directories with changes are walked again: a
+ * directory whose files changed has its own files measured, and a subdirectory
+ * that was created, removed or moved is walked from scratch.
+ * Parameters:
+ *  - ctx: Cancelling this ends the loop
+ *  - dirs: Paths to the directories
+ * Returns:
+ *  - (bool, bool): false if the last update had any errors, and true if it was
+ *    cut short by the interruption rather than finishing
+ */
+func watchChanges(ctx context.Context, dirs []string) (bool, bool) {
+	for _, dir := range dirs {
+		if info, err := os.Stat(dir); isRemote(dir) || isArchive(dir) || (err == nil && !info.IsDir()) {
+			fmt.Fprintf(os.Stderr, "-watch-fsnotify only watches directories on disk, and %s isn't one\n", dir)
+			return false, false
+		}
+	}
+	// Each directory can only be watched for one argument
+	for i, other := range findOverlaps(dirs) {
+		if other != "" {
+			fmt.Fprintf(os.Stderr, "-watch-fsnotify can't watch %s, which overlaps %s\n", dirs[i], other)
+			return false, false
+		}
+	}
+	n, err := newDirNotifier()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return false, false
+	}
+	defer n.close()
+
+	args := make([]*watchedArg, len(dirs))
+	for i, dir := range dirs {
+		args[i] = &watchedArg{path: dir, root: filepath.Clean(dir)}
+		args[i].scan(ctx, n)
+	}
+	changes := make(chan []dirChange)
+	go func() {
+		defer close(changes)
+		for {
+			batch, err := n.read()
+			if err != nil {
+				return
+			}
+			select {
+			case changes <- batch:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Only clear the screen when it is one, so redirected output keeps every update
+	f, isFile := output.(*os.File)
+	clear := isFile && isTerminal(f)
+	for {
+		if clear {
+			fmt.Fprint(output, "\x1b[H\x1b[2J")
+		}
+		printRecord(fmt.Sprintf("Watching for changes, updated at %s", time.Now().Format("15:04:05")))
+		results := make([]dirResult, len(args))
+		for i, a := range args {
+			results[i] = a.result()
+		}
+		total, ok := printResults(results)
+		rememberScan(results, total)
+		if ctx.Err() != nil {
+			return ok, true
+		}
+
+		batch := waitForChanges(ctx, changes)
+		if batch == nil {
+			return ok, false
+		}
+		resetRun()
+		for _, a := range args {
+			if a.update(ctx, n, batch) {
+				fmt.Fprintf(os.Stderr, "Warning: missed changes to %s; walking it again\n", a.path)
+				a.scan(ctx, n)
+			}
+		}
+	}
+}
+
+/* Measure again whatever changed in the argument
+ * Parameters:
+ *  - ctx: Cancelling this aborts the walks
+ *  - n: The notifier
+ *  - batch: The changes seen, in every argument
+ * Returns:
+ *  - bool: true if changes were lost, so the whole argument has to be walked again
+ */
+func (a *watchedArg) update(ctx context.Context, n *dirNotifier, batch []dirChange) bool {
+	dirty := make(map[string]bool)
+	trees := make(map[string]bool)
+	for _, c := range batch {
+		if c.overflow {
+			return true
+		}
+		// Changes to a watched directory itself are also reported to its parent's
+		// watch, which is what counts
+		if a.own[c.dir] == nil || c.name == "" {
+			continue
+		}
+		switch {
+		case c.isDir:
+			trees[filepath.Join(c.dir, c.name)] = true
+		case c.name == ".gitignore" && gitignoreFlag:
+			// New rules can change what is counted anywhere below
+			trees[c.dir] = true
+		default:
+			dirty[c.dir] = true
+		}
+	}
+	for dir := range trees {
+		a.rescanTree(ctx, n, dir)
+	}
+	// Skip what was just walked again along with a subdirectory it's in
+	walked := func(dir string) bool {
+		for t := range trees {
+			if dir == t || isInside(dir, t) {
+				return true
+			}
+		}
+		return false
+	}
+	for dir := range dirty {
+		if !walked(dir) {
+			a.rescanDir(ctx, n, dir)
+		}
+	}
+	return false
+}